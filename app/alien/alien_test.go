@@ -0,0 +1,170 @@
+package alien
+
+import (
+	"app/eval"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientSend(t *testing.T) {
+	fake := NewFakeServer()
+	fake.Countdown = 76543
+	var apiKey atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey.Store(r.URL.Query().Get("apiKey"))
+		fake.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	client := &Client{URL: server.URL + "/", APIKey: "secret key"}
+	response, err := client.Send(context.Background(), eval.NewList(eval.NewNum(Countdown)))
+	if err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if got := fmt.Sprint(response); got != "[ 1 :: [ 76543 :: nil ] ]" {
+		t.Errorf("Unexpected response: %v", got)
+	}
+	if got := apiKey.Load(); got != "secret key" {
+		t.Errorf("Expected API key to be passed, got: %q", got)
+	}
+	if got := fmt.Sprint(fake.Requests()); got != "[[ 0 :: nil ]]" {
+		t.Errorf("Unexpected requests: %v", got)
+	}
+	if _, err := client.Send(context.Background(), eval.NewFun("cons")); err == nil {
+		t.Errorf("Expected error for data that can't be modulated")
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	tests := []struct {
+		failures int
+		status   int
+		retries  int
+		correct  bool
+		attempts int32
+	}{
+		// Test 0
+		{2, http.StatusInternalServerError, 2, true, 3},
+		// Test 1
+		{2, http.StatusInternalServerError, 1, false, 2},
+		// Test 2
+		{1, http.StatusTooManyRequests, 1, true, 2},
+		// Test 3
+		{1, http.StatusBadRequest, 3, false, 1},
+	}
+	for testId, test := range tests {
+		fake := NewFakeServer()
+		var attempts int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if int(atomic.AddInt32(&attempts, 1)) <= test.failures {
+				http.Error(w, "try again", test.status)
+				return
+			}
+			fake.ServeHTTP(w, r)
+		}))
+		client := &Client{URL: server.URL, Retries: test.retries, RetryDelay: time.Millisecond}
+		_, err := client.Send(context.Background(), eval.NewList(eval.NewNum(Countdown)))
+		server.Close()
+		if correct := err == nil; correct != test.correct {
+			t.Errorf("Test %v: Expected correct: %v, got: %v", testId, test.correct, err)
+		}
+		if got := atomic.LoadInt32(&attempts); got != test.attempts {
+			t.Errorf("Test %v: Expected %v attempts, got: %v", testId, test.attempts, got)
+		}
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	client := &Client{URL: server.URL, Timeout: 20 * time.Millisecond}
+	start := time.Now()
+	if _, err := client.Send(context.Background(), eval.NewList(eval.NewNum(Countdown))); err == nil {
+		t.Errorf("Expected timeout")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Timeout took too long: %v", elapsed)
+	}
+}
+
+func TestClientCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "try again", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	client := &Client{URL: server.URL, Retries: 3, RetryDelay: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.Send(ctx, eval.NewList(eval.NewNum(Countdown))); err == nil {
+		t.Errorf("Expected the retries to be canceled")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Canceling took too long: %v", elapsed)
+	}
+}
+
+func TestFakeServer(t *testing.T) {
+	fake := NewFakeServer()
+	fake.MaxTicks = 2
+	list := eval.NewList
+	num := eval.NewNum
+	fake.Script(Countdown, list(num(1), num(5)))
+	tests := []struct {
+		request  *eval.Node
+		expected string
+	}{
+		// Test 0
+		{list(num(Countdown)), "[ 1 :: [ 5 :: nil ] ]"},
+		// Test 1
+		{list(num(Countdown)), "[ 1 :: [ 0 :: nil ] ]"},
+		// Test 2
+		{list(num(Create), num(0)),
+			"[ 1 :: [ [ [ 0 :: [ 1000 :: nil ] ] :: [ [ 1 :: [ 1001 :: nil ] ] :: nil ] ] :: nil ] ]"},
+		// Test 3
+		{list(num(Join), num(1001), list()),
+			"[ 1 :: [ 0 :: [ [ 2 :: [ 1 :: [ [ 512 :: [ 1 :: [ 64 :: nil ] ] ] :: [ [ 16 :: [ 128 :: nil ] ] :: " +
+				"[ nil :: nil ] ] ] ] ] :: [ nil :: nil ] ] ] ]"},
+		// Test 4
+		{list(num(Start), num(1001), list(num(1), num(2), num(3), num(4))),
+			"[ 1 :: [ 1 :: [ [ 2 :: [ 1 :: [ [ 512 :: [ 1 :: [ 64 :: nil ] ] ] :: [ [ 16 :: [ 128 :: nil ] ] :: " +
+				"[ nil :: nil ] ] ] ] ] :: [ [ 0 :: [ [ 16 :: [ 128 :: nil ] ] :: [ nil :: nil ] ] ] :: nil ] ] ] ]"},
+		// Test 5
+		{list(num(Commands), num(1001), list()),
+			"[ 1 :: [ 1 :: [ [ 2 :: [ 1 :: [ [ 512 :: [ 1 :: [ 64 :: nil ] ] ] :: [ [ 16 :: [ 128 :: nil ] ] :: " +
+				"[ nil :: nil ] ] ] ] ] :: [ [ 1 :: [ [ 16 :: [ 128 :: nil ] ] :: [ nil :: nil ] ] ] :: nil ] ] ] ]"},
+		// Test 6
+		{list(num(Commands), num(1001), list()),
+			"[ 1 :: [ 2 :: [ [ 2 :: [ 1 :: [ [ 512 :: [ 1 :: [ 64 :: nil ] ] ] :: [ [ 16 :: [ 128 :: nil ] ] :: " +
+				"[ nil :: nil ] ] ] ] ] :: [ [ 2 :: [ [ 16 :: [ 128 :: nil ] ] :: [ nil :: nil ] ] ] :: nil ] ] ] ]"},
+		// Test 7
+		{list(num(Join), num(7), list()), "[ 0 :: nil ]"},
+		// Test 8
+		{list(num(9)), "[ 0 :: nil ]"},
+		// Test 9
+		{num(3), "[ 0 :: nil ]"},
+	}
+	for testId, test := range tests {
+		response, err := fake.Send(context.Background(), test.request)
+		if err != nil {
+			t.Errorf("Test %v: Failed to send: %v", testId, err)
+		} else if got := fmt.Sprint(response); got != test.expected {
+			t.Errorf("Test %v: Expected: %v, got: %v", testId, test.expected, got)
+		}
+	}
+	fake.Handle(Countdown, func(request *eval.Node) *eval.Node {
+		return list(num(1), request)
+	})
+	if response, _ := fake.Send(context.Background(), list(num(Countdown))); fmt.Sprint(response) != "[ 1 :: [ [ 0 :: nil ] :: nil ] ]" {
+		t.Errorf("Handler not used: %v", response)
+	}
+}
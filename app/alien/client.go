@@ -0,0 +1,114 @@
+// Package alien talks to the aliens' server, or to a local stand-in for it.
+//
+// Requests and responses are lists, sent modulated to /aliens/send. The first
+// item of a request says what it asks for, see the request kinds below.
+package alien
+
+import (
+	"app/eval"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Request kinds.
+const (
+	Countdown int64 = iota
+	Create
+	Join
+	Start
+	Commands
+)
+
+// SendPath is where requests are posted.
+const SendPath = "/aliens/send"
+
+// Client sends data to the aliens over HTTP. It implements eval.Sender.
+type Client struct {
+	URL        string        // Server address without SendPath.
+	APIKey     string        // Passed as the apiKey parameter, if set.
+	Timeout    time.Duration // Limit for each attempt. Zero means no limit.
+	Retries    int           // Number of extra attempts after failures.
+	RetryDelay time.Duration // Wait before the first retry, doubled for every further one.
+	HTTPClient *http.Client  // Optional.
+}
+
+// Send modulates data, posts it and returns the demodulated response. Network
+// failures and server errors are retried, rejected requests are not. Canceling
+// ctx stops the attempt and the wait for the next one.
+func (c *Client) Send(ctx context.Context, data *eval.Node) (*eval.Node, error) {
+	body, err := eval.ModulateList(data, nil)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to modulate request: %v", err))
+	}
+	address := strings.TrimRight(c.URL, "/") + SendPath
+	if c.APIKey != "" {
+		address += "?apiKey=" + url.QueryEscape(c.APIKey)
+	}
+	delay := c.RetryDelay
+	for attempt := 0; ; attempt += 1 {
+		response, retry, err := c.post(ctx, address, body)
+		if err == nil {
+			return response, nil
+		}
+		if !retry || attempt >= c.Retries {
+			return nil, err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, errors.New(fmt.Sprintf("gave up retrying: %v, after: %v", ctx.Err(), err))
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// post makes a single attempt, reporting whether a failure is worth retrying.
+func (c *Client) post(ctx context.Context, address string, body []byte) (*eval.Node, bool, error) {
+	client := c.HTTPClient
+	if client == nil {
+		client = &http.Client{}
+	}
+	if c.Timeout > 0 {
+		withTimeout := *client
+		withTimeout.Timeout = c.Timeout
+		client = &withTimeout
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, address, strings.NewReader(string(body)))
+	if err != nil {
+		return nil, false, errors.New(fmt.Sprintf("failed to make request: %v", err))
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	res, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, false, errors.New(fmt.Sprintf("failed to send: %v", err))
+		}
+		return nil, true, errors.New(fmt.Sprintf("failed to send: %v", err))
+	}
+	defer func() {
+		if err := res.Body.Close(); err != nil {
+			// Do nothing.
+		}
+	}()
+	reply, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, true, errors.New(fmt.Sprintf("failed to read response: %v", err))
+	}
+	if res.StatusCode != http.StatusOK {
+		retry := res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+		return nil, retry, errors.New(fmt.Sprintf("unexpected response: HTTP %v: %s", res.StatusCode, reply))
+	}
+	response, rem, err := eval.DemodulateList([]byte(strings.TrimSpace(string(reply))))
+	if err != nil || len(rem) > 0 {
+		return nil, false, errors.New(fmt.Sprintf("failed to demodulate response: %q", reply))
+	}
+	return response, false, nil
+}
@@ -0,0 +1,207 @@
+package alien
+
+import (
+	"app/eval"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Handler answers a single kind of request.
+type Handler func(request *eval.Node) *eval.Node
+
+// FakeServer is a local stand-in for the aliens' server. It answers every
+// known kind of request with a plausible response, which can be replaced with
+// scripted ones per kind. It can be used as an eval.Sender directly, or
+// served over HTTP as an http.Handler.
+type FakeServer struct {
+	mu        sync.Mutex
+	scripts   map[int64][]*eval.Node // Scripted responses left per kind.
+	handlers  map[int64]Handler
+	nextKey   int64
+	ticks     map[int64]int64 // Game tick per player key.
+	roles     map[int64]int64 // Role per player key.
+	requests  []*eval.Node
+	MaxTicks  int64 // Length of games played through the fake.
+	Countdown int64 // Answer to countdown requests.
+}
+
+func NewFakeServer() *FakeServer {
+	f := &FakeServer{
+		scripts:  make(map[int64][]*eval.Node),
+		handlers: make(map[int64]Handler),
+		nextKey:  1000,
+		ticks:    make(map[int64]int64),
+		roles:    make(map[int64]int64),
+		MaxTicks: 256,
+	}
+	f.handlers[Countdown] = f.countdown
+	f.handlers[Create] = f.create
+	f.handlers[Join] = f.join
+	f.handlers[Start] = f.start
+	f.handlers[Commands] = f.commands
+	return f
+}
+
+// Script queues responses for requests of kind. They are used up in order,
+// before falling back to the kind's handler.
+func (f *FakeServer) Script(kind int64, responses ...*eval.Node) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.scripts[kind] = append(f.scripts[kind], responses...)
+}
+
+// Handle replaces the handler for requests of kind. Handlers are called with
+// the server locked.
+func (f *FakeServer) Handle(kind int64, handler Handler) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[kind] = handler
+}
+
+// Requests returns the requests received so far.
+func (f *FakeServer) Requests() []*eval.Node {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*eval.Node(nil), f.requests...)
+}
+
+// Send answers data like the aliens would. Unknown requests get the aliens'
+// error response, a list holding 0. The answer is immediate, so ctx is unused.
+func (f *FakeServer) Send(ctx context.Context, data *eval.Node) (*eval.Node, error) {
+	// Only take what could have gone over the wire.
+	request, err := eval.Modem(data)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to modulate request: %v", err))
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, request)
+	items, ok := request.Items()
+	if !ok || len(items) == 0 {
+		return errorResponse(), nil
+	}
+	kind, ok := items[0].Int64()
+	if !ok {
+		return errorResponse(), nil
+	}
+	if scripted := f.scripts[kind]; len(scripted) > 0 {
+		f.scripts[kind] = scripted[1:]
+		return scripted[0], nil
+	}
+	if handler, ok := f.handlers[kind]; ok {
+		return handler(request), nil
+	}
+	return errorResponse(), nil
+}
+
+func (f *FakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != SendPath || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	request, rem, err := eval.DemodulateList([]byte(strings.TrimSpace(string(body))))
+	if err != nil || len(rem) > 0 {
+		http.Error(w, fmt.Sprintf("failed to demodulate request: %q", body), http.StatusBadRequest)
+		return
+	}
+	response, err := f.Send(r.Context(), request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	bytes, err := eval.ModulateList(response, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := w.Write(bytes); err != nil {
+		// Do nothing.
+	}
+}
+
+func num(v int64) *eval.Node {
+	return eval.NewNum(v)
+}
+
+func errorResponse() *eval.Node {
+	return eval.NewList(num(0))
+}
+
+func (f *FakeServer) countdown(*eval.Node) *eval.Node {
+	return eval.NewList(num(1), num(f.Countdown))
+}
+
+// create answers with the keys of a new game's attacker and defender.
+func (f *FakeServer) create(*eval.Node) *eval.Node {
+	attacker, defender := f.nextKey, f.nextKey+1
+	f.nextKey += 2
+	f.roles[attacker], f.roles[defender] = 0, 1
+	return eval.NewList(num(1), eval.NewList(
+		eval.NewList(num(0), num(attacker)),
+		eval.NewList(num(1), num(defender))))
+}
+
+// playerKey returns the key of a request about a game, if it's known.
+func (f *FakeServer) playerKey(request *eval.Node) (int64, bool) {
+	items, _ := request.Items()
+	if len(items) < 2 {
+		return 0, false
+	}
+	key, ok := items[1].Int64()
+	if !ok {
+		return 0, false
+	}
+	_, known := f.roles[key]
+	return key, known
+}
+
+// gameResponse describes the game of key: its stage, static information about
+// it and its state.
+func (f *FakeServer) gameResponse(key int64, stage int64) *eval.Node {
+	tick := f.ticks[key]
+	if tick >= f.MaxTicks {
+		stage = 2
+	}
+	static := eval.NewList(num(f.MaxTicks), num(f.roles[key]),
+		eval.NewList(num(512), num(1), num(64)), eval.NewList(num(16), num(128)), eval.NewList())
+	var state *eval.Node = eval.NewList()
+	if stage > 0 {
+		state = eval.NewList(num(tick), eval.NewList(num(16), num(128)), eval.NewList())
+	}
+	return eval.NewList(num(1), num(stage), static, state)
+}
+
+func (f *FakeServer) join(request *eval.Node) *eval.Node {
+	key, ok := f.playerKey(request)
+	if !ok {
+		return errorResponse()
+	}
+	return f.gameResponse(key, 0)
+}
+
+func (f *FakeServer) start(request *eval.Node) *eval.Node {
+	key, ok := f.playerKey(request)
+	if !ok {
+		return errorResponse()
+	}
+	return f.gameResponse(key, 1)
+}
+
+func (f *FakeServer) commands(request *eval.Node) *eval.Node {
+	key, ok := f.playerKey(request)
+	if !ok {
+		return errorResponse()
+	}
+	f.ticks[key] += 1
+	return f.gameResponse(key, 1)
+}
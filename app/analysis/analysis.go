@@ -0,0 +1,172 @@
+// Package analysis studies how the definitions of a program refer to each
+// other.
+//
+// The references between definitions form a graph. Its strongly connected
+// components are the groups of mutually recursive definitions, and the
+// definitions that can't be reached from the root are dead code.
+package analysis
+
+import (
+	"app/eval"
+	"sort"
+)
+
+// Definition is a top-level definition and its place in the graph.
+type Definition struct {
+	Name      string
+	Refs      []string // Definitions referred to, sorted.
+	Nodes     int      // Size of the definition's tree.
+	Component int      // Index into Graph.Components.
+	Recursive bool     // Refers to itself, directly or through others.
+	Reachable bool     // Reachable from Graph.Root.
+}
+
+// Graph is the reference graph of a set of definitions.
+type Graph struct {
+	Root        string
+	Definitions []*Definition // In the order of eval.DefinitionNames.
+	// Components are the strongly connected components, each sorted like
+	// Definitions. A component comes after the components it refers to.
+	Components  [][]string
+	Unreachable []string            // Definitions not reachable from Root.
+	Undefined   map[string][]string // Missing definitions, by the ones referring to them.
+	byName      map[string]*Definition
+}
+
+// Analyze builds the reference graph of vars, with root as the entry point.
+func Analyze(vars map[string]*eval.Node, root string) *Graph {
+	g := &Graph{Root: root, Undefined: make(map[string][]string), byName: make(map[string]*Definition)}
+	for _, name := range eval.DefinitionNames(vars) {
+		def := &Definition{Name: name, Refs: vars[name].Refs(), Nodes: vars[name].NodeCount()}
+		g.Definitions = append(g.Definitions, def)
+		g.byName[name] = def
+	}
+	for _, def := range g.Definitions {
+		for _, ref := range def.Refs {
+			if _, ok := vars[ref]; !ok {
+				g.Undefined[ref] = append(g.Undefined[ref], def.Name)
+			}
+		}
+	}
+	g.findComponents()
+	g.markReachable()
+	return g
+}
+
+// Definition returns the definition called name, or nil.
+func (g *Graph) Definition(name string) *Definition {
+	return g.byName[name]
+}
+
+// refs returns the definitions def refers to that exist.
+func (g *Graph) refs(def *Definition) []*Definition {
+	var refs []*Definition
+	for _, ref := range def.Refs {
+		if to, ok := g.byName[ref]; ok {
+			refs = append(refs, to)
+		}
+	}
+	return refs
+}
+
+// findComponents fills in Components using Tarjan's algorithm, which finds
+// components after all those they refer to.
+func (g *Graph) findComponents() {
+	index := make(map[*Definition]int)
+	lowLink := make(map[*Definition]int)
+	onStack := make(map[*Definition]bool)
+	position := make(map[*Definition]int)
+	for pos, def := range g.Definitions {
+		position[def] = pos
+	}
+	var stack []*Definition
+	var visit func(def *Definition)
+	visit = func(def *Definition) {
+		index[def] = len(index)
+		lowLink[def] = index[def]
+		stack = append(stack, def)
+		onStack[def] = true
+		for _, to := range g.refs(def) {
+			if _, ok := index[to]; !ok {
+				visit(to)
+				if lowLink[to] < lowLink[def] {
+					lowLink[def] = lowLink[to]
+				}
+			} else if onStack[to] && index[to] < lowLink[def] {
+				lowLink[def] = index[to]
+			}
+		}
+		if lowLink[def] != index[def] {
+			return
+		}
+		var component []*Definition
+		for {
+			member := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[member] = false
+			component = append(component, member)
+			if member == def {
+				break
+			}
+		}
+		g.addComponent(component, position)
+	}
+	for _, def := range g.Definitions {
+		if _, ok := index[def]; !ok {
+			visit(def)
+		}
+	}
+}
+
+func (g *Graph) addComponent(component []*Definition, position map[*Definition]int) {
+	sort.Slice(component, func(i, j int) bool {
+		return position[component[i]] < position[component[j]]
+	})
+	var names []string
+	for _, def := range component {
+		def.Component = len(g.Components)
+		def.Recursive = len(component) > 1
+		names = append(names, def.Name)
+	}
+	if len(component) == 1 {
+		def := component[0]
+		i := sort.SearchStrings(def.Refs, def.Name)
+		def.Recursive = i < len(def.Refs) && def.Refs[i] == def.Name
+	}
+	g.Components = append(g.Components, names)
+}
+
+func (g *Graph) markReachable() {
+	var queue []*Definition
+	if root, ok := g.byName[g.Root]; ok {
+		root.Reachable = true
+		queue = append(queue, root)
+	}
+	for len(queue) > 0 {
+		def := queue[0]
+		queue = queue[1:]
+		for _, to := range g.refs(def) {
+			if !to.Reachable {
+				to.Reachable = true
+				queue = append(queue, to)
+			}
+		}
+	}
+	g.Unreachable = []string{}
+	for _, def := range g.Definitions {
+		if !def.Reachable {
+			g.Unreachable = append(g.Unreachable, def.Name)
+		}
+	}
+}
+
+// Recursive returns the components of mutually or self recursive definitions.
+func (g *Graph) Recursive() [][]string {
+	var recursive [][]string
+	for _, component := range g.Components {
+		if g.byName[component[0]].Recursive {
+			recursive = append(recursive, component)
+		}
+	}
+	return recursive
+}
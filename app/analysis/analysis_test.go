@@ -0,0 +1,121 @@
+package analysis
+
+import (
+	"app/eval"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func analyze(t *testing.T, definitions string) *Graph {
+	var parser eval.Parser
+	if _, err := parser.Parse(definitions); err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	return Analyze(parser.Vars, "galaxy")
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		definitions string
+		expected    string
+	}{
+		// Test 0
+		{":1 = 1\ngalaxy = :1", "[[:1] [galaxy]] recursive: [] unreachable: [] undefined: map[]"},
+		// Test 1
+		{":1 = ap inc :1\n:2 = :3\n:3 = ap ap cons :2 :1\ngalaxy = :3",
+			"[[:1] [:2 :3] [galaxy]] recursive: [[:1] [:2 :3]] unreachable: [] undefined: map[]"},
+		// Test 2
+		{":1 = :2\n:2 = :1\n:3 = ap inc :4\ngalaxy = ap :3 :5",
+			"[[:1 :2] [:3] [galaxy]] recursive: [[:1 :2]] unreachable: [:1 :2] undefined: map[:4:[:3] :5:[galaxy]]"},
+		// Test 3
+		{":1 = 1\n:10 = :2\n:2 = :1", "[[:1] [:2] [:10]] recursive: [] unreachable: [:1 :2 :10] undefined: map[]"},
+	}
+	for testId, test := range tests {
+		g := analyze(t, test.definitions)
+		got := fmt.Sprintf("%v recursive: %v unreachable: %v undefined: %v", g.Components, g.Recursive(),
+			g.Unreachable, g.Undefined)
+		if got != test.expected {
+			t.Errorf("Test %v: Expected: %v, got: %v", testId, test.expected, got)
+		}
+		for pos, component := range g.Components {
+			for _, name := range component {
+				if g.Definition(name).Component != pos {
+					t.Errorf("Test %v: Expected %v in component %v, got: %v", testId, name, pos,
+						g.Definition(name).Component)
+				}
+			}
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	g := analyze(t, ":1 = :2\n:2 = ap :1 :3\n:3 = 7\n:4 = 1\ngalaxy = ap inc :2")
+	expected := `digraph definitions {
+  node [shape=box, fontname=monospace];
+  ":3" [label=":3\n1 nodes"];
+  subgraph cluster_1 {
+    style=rounded;
+    ":1" [label=":1\n1 nodes"];
+    ":2" [label=":2\n3 nodes"];
+  }
+  ":4" [label=":4\n1 nodes", style=dashed, color=gray, fontcolor=gray];
+  "galaxy" [label="galaxy\n3 nodes", penwidth=2];
+  ":1" -> ":2";
+  ":2" -> ":1";
+  ":2" -> ":3";
+  "galaxy" -> ":2";
+}
+`
+	var dot strings.Builder
+	if err := g.WriteDOT(&dot); err != nil {
+		t.Fatalf("Failed to write DOT: %v", err)
+	}
+	if dot.String() != expected {
+		t.Errorf("Expected:\n%v\ngot:\n%v", expected, dot.String())
+	}
+	var out bytes.Buffer
+	if err := g.WriteJSON(&out); err != nil {
+		t.Fatalf("Failed to write JSON: %v", err)
+	}
+	var summary graphJSON
+	if err := json.Unmarshal(out.Bytes(), &summary); err != nil {
+		t.Fatalf("Failed to read JSON: %v", err)
+	}
+	if got := fmt.Sprint(summary.Nodes, summary.Recursive, summary.Unreachable, len(summary.Definitions)); got != "9 [[:1 :2]] [:4] 5" {
+		t.Errorf("Unexpected summary: %v", got)
+	}
+	if expected := "5 definitions, 9 nodes, 1 recursive components, 1 unreachable from galaxy\n" +
+		"recursive: :1 :2\nunreachable: :4"; g.String() != expected {
+		t.Errorf("Expected:\n%v\ngot:\n%v", expected, g.String())
+	}
+}
+
+func TestGalaxy(t *testing.T) {
+	bytes, err := ioutil.ReadFile("../galaxy.txt")
+	if err != nil {
+		t.Skipf("galaxy.txt not available: %v", err)
+	}
+	var parser eval.Parser
+	if _, err := parser.Parse(string(bytes)); err != nil {
+		t.Fatalf("Failed to parse galaxy.txt: %v", err)
+	}
+	g := Analyze(parser.Vars, "galaxy")
+	if len(g.Definitions) != len(parser.Vars) || len(g.Undefined) != 0 {
+		t.Errorf("Expected %v definitions and none undefined, got: %v", len(parser.Vars), g)
+	}
+	if !g.Definition("galaxy").Reachable || !g.Definition(":1338").Reachable {
+		t.Errorf("Expected galaxy to reach :1338")
+	}
+	// Every definition referring to itself is in a recursive component.
+	recursive := 0
+	for _, component := range g.Recursive() {
+		recursive += len(component)
+	}
+	if recursive < parser.RecursiveCount {
+		t.Errorf("Expected at least %v recursive definitions, got: %v", parser.RecursiveCount, recursive)
+	}
+}
@@ -0,0 +1,119 @@
+package analysis
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type definitionJSON struct {
+	Name      string   `json:"name"`
+	Refs      []string `json:"refs"`
+	Nodes     int      `json:"nodes"`
+	Component int      `json:"component"`
+	Recursive bool     `json:"recursive"`
+	Reachable bool     `json:"reachable"`
+}
+
+type graphJSON struct {
+	Root        string              `json:"root"`
+	Nodes       int                 `json:"nodes"`
+	Recursive   [][]string          `json:"recursive"`
+	Unreachable []string            `json:"unreachable"`
+	Undefined   map[string][]string `json:"undefined"`
+	Definitions []definitionJSON    `json:"definitions"`
+}
+
+// Nodes returns the total size of the definitions.
+func (g *Graph) Nodes() int {
+	nodes := 0
+	for _, def := range g.Definitions {
+		nodes += def.Nodes
+	}
+	return nodes
+}
+
+// WriteJSON writes a summary of g to w as JSON, followed by every definition.
+func (g *Graph) WriteJSON(w io.Writer) error {
+	out := graphJSON{Root: g.Root, Nodes: g.Nodes(), Recursive: [][]string{}, Unreachable: g.Unreachable,
+		Undefined: g.Undefined, Definitions: []definitionJSON{}}
+	out.Recursive = append(out.Recursive, g.Recursive()...)
+	for _, def := range g.Definitions {
+		out.Definitions = append(out.Definitions, definitionJSON{def.Name, def.Refs, def.Nodes, def.Component,
+			def.Recursive, def.Reachable})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// WriteDOT writes g to w in the Graphviz DOT language. Recursive components
+// are drawn as clusters and unreachable definitions are grayed out.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "digraph definitions {")
+	fmt.Fprintln(out, "  node [shape=box, fontname=monospace];")
+	node := func(indent string, def *Definition) {
+		attributes := fmt.Sprintf("label=%q", fmt.Sprintf("%v\n%v nodes", def.Name, def.Nodes))
+		if def.Name == g.Root {
+			attributes += ", penwidth=2"
+		}
+		if !def.Reachable {
+			attributes += ", style=dashed, color=gray, fontcolor=gray"
+		}
+		fmt.Fprintf(out, "%v%q [%v];\n", indent, def.Name, attributes)
+	}
+	for id, component := range g.Components {
+		first := g.byName[component[0]]
+		if !first.Recursive {
+			node("  ", first)
+			continue
+		}
+		fmt.Fprintf(out, "  subgraph cluster_%v {\n", id)
+		fmt.Fprintln(out, "    style=rounded;")
+		for _, name := range component {
+			node("    ", g.byName[name])
+		}
+		fmt.Fprintln(out, "  }")
+	}
+	var undefined []string
+	for name := range g.Undefined {
+		undefined = append(undefined, name)
+	}
+	sort.Strings(undefined)
+	for _, name := range undefined {
+		fmt.Fprintf(out, "  %q [label=%q, color=red];\n", name, name+"\nundefined")
+	}
+	for _, def := range g.Definitions {
+		for _, ref := range def.Refs {
+			fmt.Fprintf(out, "  %q -> %q;\n", def.Name, ref)
+		}
+	}
+	fmt.Fprintln(out, "}")
+	return out.Flush()
+}
+
+// String summarizes g: its size, the recursive components and the dead code.
+func (g *Graph) String() string {
+	recursive := g.Recursive()
+	lines := []string{fmt.Sprintf("%v definitions, %v nodes, %v recursive components, %v unreachable from %v",
+		len(g.Definitions), g.Nodes(), len(recursive), len(g.Unreachable), g.Root)}
+	for _, component := range recursive {
+		lines = append(lines, "recursive: "+strings.Join(component, " "))
+	}
+	if len(g.Unreachable) > 0 {
+		lines = append(lines, "unreachable: "+strings.Join(g.Unreachable, " "))
+	}
+	var undefined []string
+	for name, users := range g.Undefined {
+		undefined = append(undefined, fmt.Sprintf("%v (used by %v)", name, strings.Join(users, " ")))
+	}
+	sort.Strings(undefined)
+	for _, line := range undefined {
+		lines = append(lines, "undefined: "+line)
+	}
+	return strings.Join(lines, "\n")
+}
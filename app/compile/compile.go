@@ -0,0 +1,210 @@
+// Package compile translates a small functional language into definitions of
+// galaxy combinators, which the eval package reduces like those of galaxy.txt.
+//
+// A program is a list of definitions, each starting at the beginning of a
+// line and continuing on indented lines:
+//
+//	-- Comments run to the end of the line.
+//	twice f x = f (f x)
+//	fact n = if n == 0 then 1 else n * fact (n - 1)
+//	sum xs = if isnil xs then 0 else car xs + sum (cdr xs)
+//	galaxy = let xs = [1, 2, 3] in twice inc (sum (fact 3 : xs))
+//
+// Expressions are lambdas such as '\x y -> x', 'let name params = value in
+// body', 'if cond then x else y', applications, integers, lists such as
+// '[1, 2]', and the operators ':' (cons), '+', '-', '*', '/', '==', '/=', '<',
+// '<=', '>' and '>=', which bind as they do in Haskell. A let may refer to
+// itself. Names are looked up among the variables in scope, then the
+// definitions, then the builtins of galaxy such as 'car' or 'isnil'.
+//
+// Lambdas are removed by bracket abstraction into the combinators s, t (which
+// is K), i, b and c.
+package compile
+
+import (
+	"app/eval"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// builtins are the functions of galaxy that programs may call by name.
+var builtins = map[string]bool{
+	"add": true, "b": true, "c": true, "car": true, "cdr": true, "checkerboard": true, "cons": true, "dec": true,
+	"dem": true, "demlist": true, "div": true, "draw": true, "eq": true, "f": true, "f38": true, "i": true,
+	"if0": true, "inc": true, "interact": true, "isnil": true, "lt": true, "mod": true, "modem": true,
+	"modlist": true, "mul": true, "multipledraw": true, "neg": true, "nil": true, "pwr2": true, "s": true,
+	"send": true, "statelessdraw": true, "t": true, "vec": true,
+}
+
+// Config says how to name the compiled definitions.
+type Config struct {
+	First int64 // Number of the first definition, which is called ':First'.
+}
+
+// Program is a compiled program.
+type Program struct {
+	Vars map[string]*eval.Node // Definitions by their galaxy names, such as ':1'.
+	// Names maps the names of the source to those in Vars. Definitions are
+	// numbered in order, except for 'galaxy', which keeps its name.
+	Names map[string]string
+}
+
+// Compile reads a program and translates it into combinators.
+func Compile(r io.Reader, config Config) (*Program, error) {
+	definitions, err := lex(r)
+	if err != nil {
+		return nil, err
+	}
+	program := &Program{Vars: make(map[string]*eval.Node), Names: make(map[string]string)}
+	var names []string
+	var bodies []*expr
+	number := config.First
+	for _, tokens := range definitions {
+		p := &parser{tokens: tokens}
+		name, body, err := p.parseDefinition()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := program.Names[name.text]; ok {
+			return nil, &eval.ParseError{Pos: name.pos, Token: name.text,
+				Msg: fmt.Sprintf("'%v' is already defined", name.text)}
+		}
+		program.Names[name.text] = name.text
+		if name.text != "galaxy" {
+			program.Names[name.text] = fmt.Sprintf(":%v", number)
+			number += 1
+		}
+		names = append(names, name.text)
+		bodies = append(bodies, body)
+	}
+	for pos, body := range bodies {
+		term, err := program.lower(body, nil)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%v: %v", names[pos], err))
+		}
+		program.Vars[program.Names[names[pos]]] = toNode(term)
+	}
+	return program, nil
+}
+
+// Write writes the definitions in the syntax of galaxy.txt.
+func (p *Program) Write(w io.Writer) error {
+	return eval.WriteDefinitions(w, p.Vars)
+}
+
+// Parser returns a Parser holding the definitions, to make reducers with.
+func (p *Program) Parser() *eval.Parser {
+	parser := &eval.Parser{Vars: p.Vars}
+	for _, n := range p.Vars {
+		parser.NodeCount += n.NodeCount()
+	}
+	return parser
+}
+
+// lower resolves the names in e and abstracts its lambdas away, returning a
+// term of numbers, names and applications. The variables in scope, innermost
+// last, are left in the term.
+func (p *Program) lower(e *expr, scope []string) (*expr, error) {
+	switch e.exprType {
+	case nameExpr:
+		if e.kind == builtin {
+			return e, nil
+		}
+		for pos := len(scope) - 1; pos >= 0; pos -= 1 {
+			if scope[pos] == e.name {
+				return &expr{exprType: nameExpr, name: e.name, kind: variable, pos: e.pos}, nil
+			}
+		}
+		if name, ok := p.Names[e.name]; ok {
+			return &expr{exprType: nameExpr, name: name, kind: definition, pos: e.pos}, nil
+		}
+		if builtins[e.name] {
+			return &expr{exprType: nameExpr, name: e.name, kind: builtin, pos: e.pos}, nil
+		}
+		return nil, &eval.ParseError{Pos: e.pos, Token: e.name, Msg: fmt.Sprintf("unknown name '%v'", e.name)}
+	case apExpr:
+		fun, err := p.lower(e.fun, scope)
+		if err != nil {
+			return nil, err
+		}
+		arg, err := p.lower(e.arg, scope)
+		if err != nil {
+			return nil, err
+		}
+		return newAp(fun, arg), nil
+	case lambdaExpr:
+		body, err := p.lower(e.fun, append(scope, e.name))
+		if err != nil {
+			return nil, err
+		}
+		return abstract(e.name, body), nil
+	case letExpr:
+		value := e.arg
+		if occurs(e.name, value) {
+			value = newAp(fixpoint(), &expr{exprType: lambdaExpr, name: e.name, fun: value, pos: e.pos})
+		}
+		return p.lower(newAp(&expr{exprType: lambdaExpr, name: e.name, fun: e.fun, pos: e.pos}, value), scope)
+	}
+	return e, nil
+}
+
+// fixpoint returns \f -> (\x -> f (x x)) (\x -> f (x x)), which applied to a
+// function gives a value v with v = f v.
+func fixpoint() *expr {
+	half := &expr{exprType: lambdaExpr, name: "x", fun: newAp(newName("f", variable),
+		newAp(newName("x", variable), newName("x", variable)))}
+	return &expr{exprType: lambdaExpr, name: "f", fun: newAp(half, half)}
+}
+
+// occurs reports whether the variable x is free in e.
+func occurs(x string, e *expr) bool {
+	switch e.exprType {
+	case nameExpr:
+		return e.name == x && (e.kind == unresolved || e.kind == variable)
+	case apExpr:
+		return occurs(x, e.fun) || occurs(x, e.arg)
+	case lambdaExpr, letExpr:
+		return e.name != x && (occurs(x, e.fun) || e.arg != nil && occurs(x, e.arg))
+	}
+	return false
+}
+
+// abstract returns a term without x that, applied to a value, equals e with x
+// replaced by the value. Variables other than x are left as they are.
+func abstract(x string, e *expr) *expr {
+	combinator := func(name string, args ...*expr) *expr {
+		return newBuiltinAp(name, e.pos, args...)
+	}
+	switch {
+	case !occurs(x, e):
+		return combinator("t", e)
+	case e.exprType != apExpr:
+		return combinator("i")
+	case !occurs(x, e.fun) && e.arg.exprType == nameExpr:
+		// The argument is x, so the function is the same.
+		return e.fun
+	case !occurs(x, e.fun):
+		return combinator("b", e.fun, abstract(x, e.arg))
+	case !occurs(x, e.arg):
+		return combinator("c", abstract(x, e.fun), e.arg)
+	}
+	return combinator("s", abstract(x, e.fun), abstract(x, e.arg))
+}
+
+// toNode returns the node of a lowered term.
+func toNode(e *expr) *eval.Node {
+	switch e.exprType {
+	case numExpr:
+		if e.num.IsInt64() {
+			return eval.NewNum(e.num.Int64())
+		}
+		return eval.NewBigNum(e.num)
+	case apExpr:
+		return eval.NewAp(toNode(e.fun), toNode(e.arg))
+	}
+	if e.kind == definition {
+		return eval.NewRef(e.name)
+	}
+	return eval.NewFun(e.name)
+}
@@ -0,0 +1,135 @@
+package compile
+
+import (
+	"app/eval"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		program  string
+		expected string
+	}{
+		// Test 0
+		{"k x y = x", ":1 = t\n"},
+		// Test 1
+		{"id = \\x -> x\nflip f x y = f y x", ":1 = i\n:2 = c\n"},
+		// Test 2
+		{"twice f x = f (f x)", ":1 = ap ap s b i\n"},
+		// Test 3
+		{"-- Comment\nfive = 2 + 3 -- More\n  * -1\ngalaxy = [five, 10000000000000000000000]",
+			":1 = ap ap add 2 ap ap mul 3 -1\ngalaxy = ap ap cons :1 ap ap cons 10000000000000000000000 nil\n"},
+		// Test 4
+		{"s x = \\s -> s x", ":1 = ap c i\n"},
+	}
+	for testId, test := range tests {
+		program, err := Compile(strings.NewReader(test.program), Config{First: 1})
+		if err != nil {
+			t.Errorf("Test %v: Failed to compile: %v", testId, err)
+			continue
+		}
+		var b strings.Builder
+		if err := program.Write(&b); err != nil {
+			t.Errorf("Test %v: Failed to write: %v", testId, err)
+		}
+		if b.String() != test.expected {
+			t.Errorf("Test %v: Expected:\n%v\ngot:\n%v", testId, test.expected, b.String())
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		program  string
+		expected string
+	}{
+		// Test 0
+		{"galaxy = 1 + 2 * 3 - 4 / 2", "5"},
+		// Test 1
+		{"fact n = if n == 0 then 1 else n * fact (n - 1)\ngalaxy = fact 10", "3628800"},
+		// Test 2
+		{"map f xs = if isnil xs then nil else f (car xs) : map f (cdr xs)\ngalaxy = map (\\x -> x * x) [1, 2, 3]",
+			"[ 1 :: [ 4 :: [ 9 :: nil ] ] ]"},
+		// Test 3
+		{"galaxy = let double x = x + x in double (double 5)", "20"},
+		// Test 4
+		{"galaxy = let go n acc = if n < 1 then acc else go (n - 1) (acc + n) in go 100 0", "5050"},
+		// Test 5
+		{"galaxy = [1 < 2, 2 < 2, 2 <= 2, 3 <= 2, 3 > 2, 2 >= 3, 1 == 1, 1 /= 1]",
+			"[ t :: [ f :: [ t :: [ f :: [ t :: [ f :: [ t :: [ f :: nil ] ] ] ] ] ] ] ]"},
+		// Test 6
+		{"galaxy = 1 : 2 : [-3, - (4 + 1)]", "[ 1 :: [ 2 :: [ -3 :: [ -5 :: nil ] ] ] ]"},
+		// Test 7
+		{"even n = if n == 0 then t else odd (n - 1)\nodd n = if n == 0 then f else even (n - 1)\n" +
+			"galaxy = [even 10, odd 10]", "[ t :: [ f :: nil ] ]"},
+		// Test 8
+		{"galaxy = (\\x x -> x) 1 2 + (\\car -> car) 3 + car [4]", "9"},
+		// Test 9
+		{"galaxy = twice \\x -> x * 3\ntwice f = f (f 1)", "9"},
+	}
+	for testId, test := range tests {
+		program, err := Compile(strings.NewReader(test.program), Config{First: 1})
+		if err != nil {
+			t.Errorf("Test %v: Failed to compile: %v", testId, err)
+			continue
+		}
+		// The program runs the same when read back from its definitions.
+		var b strings.Builder
+		if err := program.Write(&b); err != nil {
+			t.Errorf("Test %v: Failed to write: %v", testId, err)
+			continue
+		}
+		var parser eval.Parser
+		if _, err := parser.Parse(b.String()); err != nil {
+			t.Errorf("Test %v: Failed to parse:\n%v\nerror: %v", testId, b.String(), err)
+			continue
+		}
+		for _, parser := range []*eval.Parser{program.Parser(), &parser} {
+			reducer := parser.NewReducerWithConfig(eval.NewRef("galaxy"), eval.ReducerConfig{MaxStepCount: 100000})
+			result, err := reducer.ReduceRoot()
+			if err != nil {
+				t.Errorf("Test %v: Failed to reduce:\n%v\nerror: %v", testId, b.String(), err)
+			} else if fmt.Sprint(result) != test.expected {
+				t.Errorf("Test %v: Expected: %v, got: %v", testId, test.expected, result)
+			}
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		program  string
+		expected string
+	}{
+		// Test 0
+		{"x = y", "x: line 1, column 5: unknown name 'y'"},
+		// Test 1
+		{"x = 1\nx = 2", "line 2, column 1: 'x' is already defined"},
+		// Test 2
+		{"x = (1 + 2", "line 1, column 11: expected ')' after '(' at line 1, column 5, got end of definition"},
+		// Test 3
+		{"x = if 1 then 2\ny = 3", "line 2, column 1: expected 'else' after 'if' at line 1, column 5, got end of definition"},
+		// Test 4
+		{"x = \\ -> 1", "line 1, column 7: expected variable after '\\' at line 1, column 5, got '->'"},
+		// Test 5
+		{"x = 1 < 2 < 3", "line 1, column 11: unexpected '<'"},
+		// Test 6
+		{"x = [1, 2", "line 1, column 10: expected ',' or ']' after '[' at line 1, column 5, got end of definition"},
+		// Test 7
+		{"  x = 1", "line 1, column 3: expected a definition at the start of the line"},
+		// Test 8
+		{"x = 1 # 2", "line 1, column 7: unexpected '#'"},
+		// Test 9
+		{"let = 1", "line 1, column 1: expected a definition, got 'let'"},
+		// Test 10
+		{"x = *", "line 1, column 5: expected expression after '=' at line 1, column 3, got '*'"},
+	}
+	for testId, test := range tests {
+		_, err := Compile(strings.NewReader(test.program), Config{First: 1})
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("Test %v: Expected error: %v, got: %v", testId, test.expected, err)
+		}
+	}
+}
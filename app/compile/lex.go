@@ -0,0 +1,110 @@
+package compile
+
+import (
+	"app/eval"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode"
+)
+
+// token is a name, a number or a symbol. The empty token ends a definition.
+type token struct {
+	text string
+	pos  eval.Pos
+}
+
+// symbols are the tokens made of punctuation, longest first.
+var symbols = []string{"->", "==", "/=", "<=", ">=", "(", ")", "[", "]", ",", `\`, "=", "<", ">", "+", "-", "*",
+	"/", ":"}
+
+// keywords are the names that can't be variables or definitions.
+var keywords = map[string]bool{"let": true, "in": true, "if": true, "then": true, "else": true}
+
+func isNameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '\''
+}
+
+func (t token) isName() bool {
+	r := []rune(t.text)
+	return len(r) > 0 && (unicode.IsLetter(r[0]) || r[0] == '_') && !keywords[t.text]
+}
+
+func (t token) isNumber() bool {
+	return len(t.text) > 0 && unicode.IsDigit([]rune(t.text)[0])
+}
+
+// lex splits the source into definitions, each a list of tokens ending with
+// the empty token. Definitions start at the beginning of a line and continue
+// on indented lines.
+func lex(r io.Reader) ([][]token, error) {
+	bytes, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	src := []rune(string(bytes))
+	var definitions [][]token
+	end := func(pos eval.Pos) {
+		if len(definitions) > 0 {
+			last := len(definitions) - 1
+			definitions[last] = append(definitions[last], token{pos: pos})
+		}
+	}
+	pos := eval.Pos{Line: 1, Col: 1}
+	for i := 0; i < len(src); {
+		start := pos
+		length := 0
+		switch r := src[i]; {
+		case r == '\n':
+			i += 1
+			pos = eval.Pos{Line: pos.Line + 1, Col: 1}
+			continue
+		case unicode.IsSpace(r):
+			i += 1
+			pos.Col += 1
+			continue
+		case strings.HasPrefix(string(src[i:min(i+2, len(src))]), "--"):
+			// Comments run to the end of the line.
+			for i < len(src) && src[i] != '\n' {
+				i += 1
+				pos.Col += 1
+			}
+			continue
+		case isNameRune(r):
+			for i+length < len(src) && isNameRune(src[i+length]) {
+				length += 1
+			}
+		default:
+			for _, symbol := range symbols {
+				if strings.HasPrefix(string(src[i:min(i+len(symbol), len(src))]), symbol) {
+					length = len(symbol)
+					break
+				}
+			}
+			if length == 0 {
+				return nil, &eval.ParseError{Pos: start, Token: string(r), Msg: fmt.Sprintf("unexpected '%c'", r)}
+			}
+		}
+		if start.Col == 1 {
+			end(start)
+			definitions = append(definitions, nil)
+		} else if len(definitions) == 0 {
+			return nil, &eval.ParseError{Pos: start, Token: string(src[i : i+length]),
+				Msg: "expected a definition at the start of the line"}
+		}
+		last := len(definitions) - 1
+		definitions[last] = append(definitions[last], token{string(src[i : i+length]), start})
+		i += length
+		pos.Col += length
+	}
+	end(pos)
+	return definitions, nil
+}
+
+func min(x, y int) int {
+	if x < y {
+		return x
+	}
+	return y
+}
@@ -0,0 +1,357 @@
+package compile
+
+import (
+	"app/eval"
+	"fmt"
+	"math/big"
+)
+
+type exprType int
+
+const (
+	numExpr exprType = iota
+	nameExpr
+	apExpr
+	lambdaExpr // Binds name in fun.
+	letExpr    // Binds name to arg in fun, and in arg too if arg refers to it.
+)
+
+// nameKind says what a name stands for, once it is resolved.
+type nameKind int
+
+const (
+	unresolved nameKind = iota
+	variable
+	definition
+	builtin
+)
+
+// expr is an expression of the source language, or a term of combinators once
+// lowered.
+type expr struct {
+	exprType exprType
+	num      *big.Int
+	name     string // Of a nameExpr, or the variable of a lambdaExpr or letExpr.
+	kind     nameKind
+	fun, arg *expr // Function and argument of an apExpr, body and value of a letExpr.
+	pos      eval.Pos
+}
+
+func newName(name string, kind nameKind) *expr {
+	return &expr{exprType: nameExpr, name: name, kind: kind}
+}
+
+func newAp(fun, arg *expr) *expr {
+	return &expr{exprType: apExpr, fun: fun, arg: arg, pos: fun.pos}
+}
+
+// newBuiltinAp returns the application of a builtin to args.
+func newBuiltinAp(name string, pos eval.Pos, args ...*expr) *expr {
+	e := &expr{exprType: nameExpr, name: name, kind: builtin, pos: pos}
+	for _, arg := range args {
+		e = newAp(e, arg)
+	}
+	return e
+}
+
+// newLambdas returns the function of params with body.
+func newLambdas(params []token, body *expr) *expr {
+	for pos := len(params) - 1; pos >= 0; pos -= 1 {
+		body = &expr{exprType: lambdaExpr, name: params[pos].text, fun: body, pos: params[pos].pos}
+	}
+	return body
+}
+
+// comparisons and the other binary operators by precedence, higher binding
+// tighter.
+var precedence = map[string]int{"==": 1, "/=": 1, "<": 1, "<=": 1, ">": 1, ">=": 1, ":": 2, "+": 3, "-": 3,
+	"*": 4, "/": 4}
+
+const maxPrecedence = 4
+
+// parser reads the tokens of a definition.
+type parser struct {
+	tokens []token
+	next   int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+// take returns the next token and moves past it, unless it ends the definition.
+func (p *parser) take() token {
+	t := p.tokens[p.next]
+	if t.text != "" {
+		p.next += 1
+	}
+	return t
+}
+
+// unexpected returns the error for finding t rather than what after another
+// token.
+func unexpected(t token, what string, after token) error {
+	got := fmt.Sprintf("'%v'", t.text)
+	if t.text == "" {
+		got = "end of definition"
+	}
+	return &eval.ParseError{Pos: t.pos, Token: t.text,
+		Msg: fmt.Sprintf("expected %v after '%v' at %v, got %v", what, after.text, after.pos, got)}
+}
+
+func (p *parser) expect(text string, after token) error {
+	if t := p.take(); t.text != text {
+		return unexpected(t, fmt.Sprintf("'%v'", text), after)
+	}
+	return nil
+}
+
+// parseNames reads the names that follow a token, such as parameters.
+func (p *parser) parseNames() []token {
+	var names []token
+	for p.peek().isName() {
+		names = append(names, p.take())
+	}
+	return names
+}
+
+// parseDefinition reads 'name params = expression'.
+func (p *parser) parseDefinition() (token, *expr, error) {
+	name := p.take()
+	if !name.isName() {
+		return name, nil, &eval.ParseError{Pos: name.pos, Token: name.text,
+			Msg: fmt.Sprintf("expected a definition, got '%v'", name.text)}
+	}
+	params := p.parseNames()
+	if err := p.expect("=", name); err != nil {
+		return name, nil, err
+	}
+	body, err := p.parseExpr()
+	if err != nil {
+		return name, nil, err
+	}
+	if t := p.peek(); t.text != "" {
+		return name, nil, &eval.ParseError{Pos: t.pos, Token: t.text, Msg: fmt.Sprintf("unexpected '%v'", t.text)}
+	}
+	return name, newLambdas(params, body), nil
+}
+
+// parseExpr reads a lambda, a let, an if or operators applied to operands.
+func (p *parser) parseExpr() (*expr, error) {
+	switch first := p.peek(); first.text {
+	case `\`:
+		p.take()
+		params := p.parseNames()
+		if len(params) == 0 {
+			return nil, unexpected(p.peek(), "variable", first)
+		}
+		if err := p.expect("->", params[len(params)-1]); err != nil {
+			return nil, err
+		}
+		body, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return newLambdas(params, body), nil
+	case "let":
+		p.take()
+		name := p.take()
+		if !name.isName() {
+			return nil, unexpected(name, "variable", first)
+		}
+		params := p.parseNames()
+		if err := p.expect("=", name); err != nil {
+			return nil, err
+		}
+		value, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("in", first); err != nil {
+			return nil, err
+		}
+		body, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return &expr{exprType: letExpr, name: name.text, fun: body, arg: newLambdas(params, value), pos: first.pos}, nil
+	case "if":
+		p.take()
+		var parts [3]*expr
+		for pos, keyword := range []string{"then", "else", ""} {
+			var err error
+			if parts[pos], err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+			if keyword != "" {
+				if err := p.expect(keyword, first); err != nil {
+					return nil, err
+				}
+			}
+		}
+		// Booleans choose between their arguments.
+		return newAp(newAp(parts[0], parts[1]), parts[2]), nil
+	}
+	return p.parseOperators(1)
+}
+
+// parseOperators reads operands joined by operators of at least the given
+// precedence. Comparisons don't chain and ':' groups to the right.
+func (p *parser) parseOperators(level int) (*expr, error) {
+	if level > maxPrecedence {
+		return p.parseUnary()
+	}
+	left, err := p.parseOperators(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for precedence[p.peek().text] == level {
+		op := p.take()
+		var right *expr
+		if op.text == ":" {
+			right, err = p.parseOperators(level)
+		} else {
+			right, err = p.parseOperators(level + 1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		left = newOperator(op, left, right)
+		if level == 1 || op.text == ":" {
+			break
+		}
+	}
+	return left, nil
+}
+
+// newOperator returns the application of builtins that op stands for.
+func newOperator(op token, left, right *expr) *expr {
+	switch op.text {
+	case "==":
+		return newBuiltinAp("eq", op.pos, left, right)
+	case "/=":
+		return newAp(newAp(newBuiltinAp("eq", op.pos, left, right), newName("f", builtin)), newName("t", builtin))
+	case "<":
+		return newBuiltinAp("lt", op.pos, left, right)
+	case ">":
+		return newBuiltinAp("lt", op.pos, right, left)
+	case "<=":
+		return newBuiltinAp("lt", op.pos, left, newBuiltinAp("inc", op.pos, right))
+	case ">=":
+		return newBuiltinAp("lt", op.pos, right, newBuiltinAp("inc", op.pos, left))
+	case ":":
+		return newBuiltinAp("cons", op.pos, left, right)
+	case "+":
+		return newBuiltinAp("add", op.pos, left, right)
+	case "-":
+		return newBuiltinAp("add", op.pos, left, negate(op, right))
+	case "*":
+		return newBuiltinAp("mul", op.pos, left, right)
+	}
+	return newBuiltinAp("div", op.pos, left, right)
+}
+
+// negate returns the negation of e, which is a number if e is one.
+func negate(op token, e *expr) *expr {
+	if e.exprType == numExpr {
+		return &expr{exprType: numExpr, num: new(big.Int).Neg(e.num), pos: op.pos}
+	}
+	return newBuiltinAp("neg", op.pos, e)
+}
+
+// parseUnary reads an application, possibly negated.
+func (p *parser) parseUnary() (*expr, error) {
+	if p.peek().text == "-" {
+		op := p.take()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return negate(op, operand), nil
+	}
+	return p.parseApplication()
+}
+
+// startsBlock reports whether t starts an expression that extends as far as
+// possible, which may be the last argument of an application.
+func startsBlock(t token) bool {
+	return t.text == `\` || t.text == "let" || t.text == "if"
+}
+
+func startsAtom(t token) bool {
+	return t.isName() || t.isNumber() || t.text == "(" || t.text == "["
+}
+
+// parseApplication reads a function and its arguments.
+func (p *parser) parseApplication() (*expr, error) {
+	if startsBlock(p.peek()) {
+		return p.parseExpr()
+	}
+	fun, err := p.parseAtom()
+	if err != nil {
+		return nil, err
+	}
+	for startsAtom(p.peek()) || startsBlock(p.peek()) {
+		block := startsBlock(p.peek())
+		var arg *expr
+		if block {
+			arg, err = p.parseExpr()
+		} else {
+			arg, err = p.parseAtom()
+		}
+		if err != nil {
+			return nil, err
+		}
+		fun = newAp(fun, arg)
+		if block {
+			break
+		}
+	}
+	return fun, nil
+}
+
+// parseAtom reads a number, a name, a list or an expression in parentheses.
+func (p *parser) parseAtom() (*expr, error) {
+	var after token
+	if p.next > 0 {
+		after = p.tokens[p.next-1]
+	}
+	t := p.take()
+	switch {
+	case t.isNumber():
+		num, ok := new(big.Int).SetString(t.text, 10)
+		if !ok {
+			return nil, &eval.ParseError{Pos: t.pos, Token: t.text, Msg: fmt.Sprintf("invalid number '%v'", t.text)}
+		}
+		return &expr{exprType: numExpr, num: num, pos: t.pos}, nil
+	case t.isName():
+		return &expr{exprType: nameExpr, name: t.text, pos: t.pos}, nil
+	case t.text == "(":
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")", t)
+	case t.text == "[":
+		var items []*expr
+		for p.peek().text != "]" {
+			if len(items) > 0 {
+				if separator := p.take(); separator.text != "," {
+					return nil, unexpected(separator, "',' or ']'", t)
+				}
+			}
+			item, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		p.take()
+		list := &expr{exprType: nameExpr, name: "nil", kind: builtin, pos: t.pos}
+		for pos := len(items) - 1; pos >= 0; pos -= 1 {
+			list = newBuiltinAp("cons", items[pos].pos, items[pos], list)
+		}
+		return list, nil
+	}
+	return nil, unexpected(t, "expression", after)
+}
@@ -0,0 +1,334 @@
+// Package decompile turns definitions of combinators back into readable
+// pseudo-Haskell, reversing the bracket abstraction that produced them.
+//
+// Partial applications of the combinators s, b, c and t are applied to new
+// variables, which become lambdas, and the combinators are reduced away:
+//
+//	:1141 = ap ap c ap ap b b ap ap c isnil nil ap ap b ap c ap ap b b cons ...
+//
+// becomes something like
+//
+//	_1141 x y = if isnil x then y else ...
+//
+// The output is in the language of the compile package where possible, with
+// definitions such as ':1141' named '_1141'.
+package decompile
+
+import (
+	"app/eval"
+	"fmt"
+)
+
+// maxSteps limits the reductions made for a definition, as terms such as
+// 'ap ap s i i ap ap s i i' don't have a normal form.
+const maxSteps = 10000
+
+type termType int
+
+const (
+	numTerm termType = iota
+	funTerm          // A builtin.
+	refTerm          // A top-level definition.
+	varTerm
+	apTerm
+	lambdaTerm
+)
+
+// term is a node being decompiled. Variables are numbered, so that terms can
+// be substituted without capturing them, and named only when printed.
+type term struct {
+	termType termType
+	name     string // Of a builtin or definition, or the value of a number.
+	id       int    // Of a variable, or the variable of a lambda.
+	fun, arg *term  // Function and argument of an application, body of a lambda.
+}
+
+func newAp(fun *term, args ...*term) *term {
+	for _, arg := range args {
+		fun = &term{termType: apTerm, fun: fun, arg: arg}
+	}
+	return fun
+}
+
+func newFun(name string) *term {
+	return &term{termType: funTerm, name: name}
+}
+
+// spine returns the function at the head of applications and its arguments.
+func spine(e *term) (*term, []*term) {
+	var args []*term
+	for ; e.termType == apTerm; e = e.fun {
+		args = append(args, e.arg)
+	}
+	for i, j := 0, len(args)-1; i < j; i, j = i+1, j-1 {
+		args[i], args[j] = args[j], args[i]
+	}
+	return e, args
+}
+
+// isFun reports whether e is the builtin called name.
+func (e *term) isFun(name string) bool {
+	return e.termType == funTerm && e.name == name
+}
+
+// occurs reports whether the variable id is free in e.
+func occurs(id int, e *term) bool {
+	switch e.termType {
+	case varTerm:
+		return e.id == id
+	case apTerm:
+		return occurs(id, e.fun) || occurs(id, e.arg)
+	case lambdaTerm:
+		return occurs(id, e.fun)
+	}
+	return false
+}
+
+// decompiler holds the state of decompiling a definition.
+type decompiler struct {
+	defs  map[string]*eval.Node
+	self  string // Name of the definition.
+	vars  int    // Number of variables made.
+	steps int    // Number of reductions made.
+}
+
+func (d *decompiler) newVar() *term {
+	d.vars += 1
+	return &term{termType: varTerm, id: d.vars}
+}
+
+// fromNode returns the term of n. Scope holds the variables of the enclosing
+// lambdas, innermost last.
+func (d *decompiler) fromNode(n *eval.Node, scope []*term) *term {
+	switch n.Type() {
+	case eval.Ap:
+		return newAp(d.fromNode(n.Fun(), scope), d.fromNode(n.Nodes[0], scope))
+	case eval.Cons:
+		return newAp(newFun("cons"), d.fromNode(n.Nodes[0], scope), d.fromNode(n.Nodes[1], scope))
+	case eval.Lambda:
+		v := d.newVar()
+		return &term{termType: lambdaTerm, id: v.id, fun: d.fromNode(n.Fun(), append(scope, v))}
+	case eval.Var:
+		if index, _ := n.Index(); index < int64(len(scope)) {
+			return scope[int64(len(scope))-1-index]
+		}
+	case eval.Num:
+		return &term{termType: numTerm, name: fmt.Sprint(n)}
+	case eval.Ref:
+		// Definitions that are builtins, such as ':1115 = cons', are replaced.
+		if def, ok := d.defs[n.Name()]; ok && def.Type() == eval.Fun {
+			return newFun(def.Name())
+		}
+		return &term{termType: refTerm, name: n.Name()}
+	case eval.Fun:
+		return newFun(n.Name())
+	}
+	return newFun(fmt.Sprint(n))
+}
+
+// subst returns a copy of e with the variables in env replaced, and new
+// variables for its lambdas.
+func (d *decompiler) subst(e *term, env map[int]*term) *term {
+	switch e.termType {
+	case varTerm:
+		if value, ok := env[e.id]; ok {
+			return value
+		}
+	case apTerm:
+		return newAp(d.subst(e.fun, env), d.subst(e.arg, env))
+	case lambdaTerm:
+		v := d.newVar()
+		env[e.id] = v
+		return &term{termType: lambdaTerm, id: v.id, fun: d.subst(e.fun, env)}
+	}
+	return e
+}
+
+// arity returns the number of arguments of the builtins that decompiling
+// reduces.
+func arity(e *term) int {
+	if e.termType == lambdaTerm {
+		return 1
+	}
+	if e.termType != funTerm {
+		return 0
+	}
+	switch e.name {
+	case "i":
+		return 1
+	case "t", "f":
+		return 2
+	case "s", "b", "c", "cons":
+		return 3
+	}
+	return 0
+}
+
+// reduce returns the result of applying head to the first arguments it
+// takes, and the other arguments.
+func (d *decompiler) reduce(head *term, args []*term) (*term, []*term) {
+	n := arity(head)
+	rest := args[n:]
+	switch {
+	case head.termType == lambdaTerm:
+		return d.subst(head.fun, map[int]*term{head.id: args[0]}), rest
+	case head.isFun("i"):
+		return args[0], rest
+	case head.isFun("t"):
+		return args[0], rest
+	case head.isFun("f"):
+		return args[1], rest
+	case head.isFun("s"):
+		return newAp(args[0], args[2], newAp(args[1], args[2])), rest
+	case head.isFun("b"):
+		return newAp(args[0], newAp(args[1], args[2])), rest
+	case head.isFun("c"):
+		return newAp(args[0], args[2], args[1]), rest
+	}
+	// A pair applied to a function gives the function its items.
+	return newAp(args[2], args[0], args[1]), rest
+}
+
+// isPartial reports whether head is a combinator applied to some but not all
+// of its arguments, which stands for a lambda.
+func isPartial(head *term, args []*term) bool {
+	if len(args) == 0 || len(args) >= arity(head) {
+		return false
+	}
+	return head.isFun("s") || head.isFun("b") || head.isFun("c") || head.isFun("t") || head.isFun("f")
+}
+
+// normalize reduces the combinators in e, turning partial applications into
+// lambdas.
+func (d *decompiler) normalize(e *term) *term {
+	head, args := spine(e)
+	for d.steps < maxSteps && arity(head) > 0 && len(args) >= arity(head) {
+		d.steps += 1
+		head, args = d.reduce(head, args)
+		head, args = spine(newAp(head, args...))
+	}
+	if isPartial(head, args) && d.steps < maxSteps {
+		v := d.newVar()
+		return newLambda(v.id, d.normalize(newAp(head, append(args, v)...)))
+	}
+	if head.termType == lambdaTerm {
+		head = newLambda(head.id, d.normalize(head.fun))
+	}
+	normalized := make([]*term, len(args))
+	for pos, arg := range args {
+		normalized[pos] = d.normalize(arg)
+	}
+	return newAp(head, normalized...)
+}
+
+// isSelector reports whether e, as the first of args arguments, is a function
+// that pairs are applied to, to get their items. Booleans are applied to two
+// arguments, and t and f are left to them.
+func isSelector(e *term, args int) bool {
+	if e.isFun("t") || e.isFun("f") {
+		return args == 1
+	}
+	return e.termType == lambdaTerm && (args == 1 || e.fun.termType == lambdaTerm)
+}
+
+// findPairs adds the variables that e takes the car, cdr or isnil of to pairs.
+func findPairs(e *term, pairs map[int]bool) {
+	head, args := spine(e)
+	if (head.isFun("car") || head.isFun("cdr") || head.isFun("isnil")) && len(args) > 0 &&
+		args[0].termType == varTerm {
+		pairs[args[0].id] = true
+	}
+	if head.termType == lambdaTerm {
+		findPairs(head.fun, pairs)
+	}
+	for _, arg := range args {
+		findPairs(arg, pairs)
+	}
+}
+
+// passes reports whether e calls the definition self with the variable id as
+// an argument.
+func passes(e *term, id int, self string) bool {
+	head, args := spine(e)
+	if head.termType == refTerm && head.name == self {
+		for _, arg := range args {
+			if arg.termType == varTerm && arg.id == id {
+				return true
+			}
+		}
+	}
+	if head.termType == lambdaTerm && passes(head.fun, id, self) {
+		return true
+	}
+	for _, arg := range args {
+		if passes(arg, id, self) {
+			return true
+		}
+	}
+	return false
+}
+
+// recursesOnTail reports whether the selector e passes the second item, the
+// tail of a list, on to the definition self, as recursions over lists do.
+func recursesOnTail(e *term, self string) bool {
+	return e.termType == lambdaTerm && e.fun.termType == lambdaTerm && passes(e.fun.fun, e.fun.id, self)
+}
+
+// selectItems rewrites the first application of a pair to a selector in e as
+// the selector applied to the items, and reports whether it did. Variables are
+// only taken to be pairs if they are in pairs, applied to t or f, or to a
+// selector that recurses on the tail, as functions such as those folds take
+// are applied to lambdas too.
+func (d *decompiler) selectItems(e *term, pairs map[int]bool) (*term, bool) {
+	head, args := spine(e)
+	if head.termType == varTerm && len(args) > 0 && isSelector(args[0], len(args)) &&
+		(pairs[head.id] || args[0].isFun("t") || args[0].isFun("f") || recursesOnTail(args[0], d.self)) {
+		d.steps += 1
+		return d.normalize(newAp(args[0], append([]*term{newAp(newFun("car"), head),
+			newAp(newFun("cdr"), head)}, args[1:]...)...)), true
+	}
+	if head.termType == lambdaTerm {
+		if body, ok := d.selectItems(head.fun, pairs); ok {
+			return newAp(newLambda(head.id, body), args...), true
+		}
+	}
+	for pos, arg := range args {
+		if selected, ok := d.selectItems(arg, pairs); ok {
+			args[pos] = selected
+			return newAp(head, args...), true
+		}
+	}
+	return e, false
+}
+
+// newLambda returns the function of the variable id with body, eta reduced,
+// or the builtin it is.
+func newLambda(id int, body *term) *term {
+	switch {
+	case body.termType == apTerm && body.arg.termType == varTerm && body.arg.id == id && !occurs(id, body.fun):
+		return body.fun
+	case body.termType == varTerm && body.id == id:
+		return newFun("i")
+	case body.isFun("i"):
+		return newFun("f")
+	case body.termType == lambdaTerm && body.fun.termType == varTerm && body.fun.id == id:
+		return newFun("t")
+	}
+	return &term{termType: lambdaTerm, id: id, fun: body}
+}
+
+// decompile returns the normal form of the definition of name in defs.
+func decompile(defs map[string]*eval.Node, name string) *term {
+	d := &decompiler{defs: defs, self: name}
+	e := d.normalize(d.fromNode(defs[name], nil))
+	for d.steps < maxSteps {
+		pairs := make(map[int]bool)
+		findPairs(e, pairs)
+		selected, ok := d.selectItems(e, pairs)
+		if !ok {
+			break
+		}
+		e = selected
+	}
+	return e
+}
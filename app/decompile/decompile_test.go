@@ -0,0 +1,148 @@
+package decompile
+
+import (
+	"app/compile"
+	"app/eval"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestDefinition(t *testing.T) {
+	tests := []struct {
+		definitions string
+		name        string
+		expected    string
+	}{
+		// Test 0
+		{":1115 = cons\n:1 = ap ap :1115 1 ap ap :1115 2 nil", ":1", "_1 = [1, 2]"},
+		// Test 1
+		{":1120 = ap ap s ap ap s ap ap c ap c ap ap s ap ap b s ap ap b ap b ap ap s i i lt eq 0 i neg", ":1120",
+			"_1120 x = if 0 <= x then x else -x"},
+		// Test 2
+		{":1124 = ap ap s ap ap b b ap ap c isnil ap s t ap ap c b ap ap s ap ap b c ap ap b ap b b ap ap b ap b ap ap s i i " +
+			"ap c eq ap c :1124", ":1124",
+			"_1124 x y = if isnil x then f\n    else if car x == y then t\n    else _1124 (cdr x) y"},
+		// Test 3
+		{":1128 = ap ap s ap ap c isnil 0 ap ap b ap add 1 ap ap b :1128 cdr", ":1128",
+			"-- length x\n_1128 x = if isnil x then 0 else 1 + _1128 (cdr x)"},
+		// Test 4
+		{":1141 = ap ap c b ap ap s ap ap b c ap ap b ap b b ap eq 0 ap ap b ap c :1141 ap add -1", ":1141",
+			"-- index x y\n_1141 x y = if 0 == y then car x else _1141 (cdr x) (y - 1)"},
+		// Test 5
+		{":1 = ap ap b ap c :2 ap ap c ap ap b b add neg", ":1", "_1 x y = _2 y (\\z -> x - z)"},
+		// Test 6
+		{":1 = ap ap s i i", ":1", "_1 x = x x"},
+		// Test 7
+		{":1 = ap ap c i ap ap c add 1", ":1", "_1 x = x (\\y -> y + 1)"},
+		// Test 8
+		{":1 = ap ap s ap ap c isnil 0 ap ap c i ap c add", ":1", "_1 x = if isnil x then 0 else cdr x + car x"},
+		// Test 9
+		{":1 = ap ap c i t", ":1", "_1 = car"},
+	}
+	for testId, test := range tests {
+		var parser eval.Parser
+		if _, err := parser.Parse(test.definitions); err != nil {
+			t.Errorf("Test %v: Failed to parse: %v", testId, err)
+			continue
+		}
+		if got := Definition(parser.Vars, test.name); got != test.expected {
+			t.Errorf("Test %v: Expected:\n%v\ngot:\n%v", testId, test.expected, got)
+		}
+	}
+}
+
+func TestCompiled(t *testing.T) {
+	tests := []struct {
+		program  string
+		expected string
+	}{
+		// Test 0
+		{"fact n = if n == 0 then 1 else n * fact (n - 1)", "_1 x = if x == 0 then 1 else x * _1 (x - 1)\n"},
+		// Test 1
+		{"map f xs = if isnil xs then nil else f (car xs) : map f (cdr xs)",
+			"-- map x y\n_1 x y = if isnil y then nil else x (car y) : _1 x (cdr y)\n"},
+		// Test 2
+		{"sign x = if x < 0 then -1 else if x == 0 then 0 else 1",
+			"_1 x = if x < 0 then -1\n    else if x == 0 then 0\n    else 1\n"},
+		// Test 3
+		{"swap p = [cdr p, car p]\npair x y = x : y : nil", "_1 x = [cdr x, car x]\n_2 x y = [x, y]\n"},
+		// Test 4
+		{"fst p = p (\\x y -> x)\nsnd p = p (\\x y -> y)\nsum p = p (\\x y -> x + y)",
+			"_1 = car\n_2 = cdr\n_3 x = x add\n"},
+		// Test 5
+		{"count x xs = foldl (\\n y -> if x <= y then 1 + n else n) 0 xs\n" +
+			"foldl f z xs = if isnil xs then z else foldl f (f z (car xs)) (cdr xs)",
+			"_1 x = _2 (\\y z -> if x <= z then 1 + y else y) 0\n" +
+				"-- foldl x y z\n_2 x y z = if isnil z then y else _2 x (x y (car z)) (cdr z)\n"},
+		// Test 6
+		{"apply g = g (\\x -> x) (\\x y -> y) 3 * -2", "_1 x = x i f 3 * -2\n"},
+		// Test 7
+		{"galaxy = \\x -> \\x -> \\y -> x", "galaxy x = t\n"},
+	}
+	for testId, test := range tests {
+		program, err := compile.Compile(strings.NewReader(test.program), compile.Config{First: 1})
+		if err != nil {
+			t.Errorf("Test %v: Failed to compile: %v", testId, err)
+			continue
+		}
+		var b strings.Builder
+		if err := WriteDefinitions(&b, program.Vars); err != nil {
+			t.Errorf("Test %v: Failed to write: %v", testId, err)
+		}
+		if b.String() != test.expected {
+			t.Errorf("Test %v: Expected:\n%v\ngot:\n%v", testId, test.expected, b.String())
+		}
+	}
+}
+
+func TestIdioms(t *testing.T) {
+	if _, err := compile.Compile(strings.NewReader(idioms), compile.Config{First: 1}); err != nil {
+		t.Fatalf("Failed to compile idioms: %v", err)
+	}
+	if len(parseIdioms()) != len(strings.Split(strings.TrimSpace(idioms), "\n")) {
+		t.Errorf("Expected an idiom per line, got: %v", len(parseIdioms()))
+	}
+	for _, i := range parseIdioms() {
+		if got, _ := recognize(i.self, i.params, i.body); got != i {
+			t.Errorf("Expected %v to be recognized, got: %v", i.name, got)
+		}
+	}
+}
+
+// TestGalaxy checks that galaxy.txt decompiles to a program that compiles back
+// to one that gives the same first screen.
+func TestGalaxy(t *testing.T) {
+	bytes, err := ioutil.ReadFile("../galaxy.txt")
+	if err != nil {
+		t.Skipf("galaxy.txt not available: %v", err)
+	}
+	var parser eval.Parser
+	if _, err := parser.Parse(string(bytes)); err != nil {
+		t.Fatalf("Failed to parse galaxy.txt: %v", err)
+	}
+	var b strings.Builder
+	if err := WriteDefinitions(&b, parser.Vars); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	program, err := compile.Compile(strings.NewReader(b.String()), compile.Config{First: 1})
+	if err != nil {
+		t.Fatalf("Failed to compile the decompiled galaxy.txt: %v", err)
+	}
+	if len(program.Vars) != len(parser.Vars) {
+		t.Errorf("Expected %v definitions, got: %v", len(parser.Vars), len(program.Vars))
+	}
+	var results []string
+	for _, parser := range []*eval.Parser{&parser, program.Parser()} {
+		call := eval.NewAp(eval.NewAp(eval.NewRef("galaxy"), eval.NewFun("nil")), eval.NewCons(eval.NewNum(0), eval.NewNum(0)))
+		result, err := parser.NewReducerWithConfig(call, eval.ReducerConfig{MaxStepCount: 10000000}).ReduceRoot()
+		if err != nil {
+			t.Fatalf("Failed to reduce: %v", err)
+		}
+		results = append(results, fmt.Sprint(result))
+	}
+	if results[0] != results[1] {
+		t.Errorf("Expected: %v, got: %v", results[0], results[1])
+	}
+}
@@ -0,0 +1,137 @@
+package decompile
+
+import (
+	"app/compile"
+	"strings"
+	"sync"
+)
+
+// idioms are well-known functions, with their arguments in Haskell's order,
+// written the way galaxy.txt has them. Definitions that decompile to the same
+// term, up to the order of their arguments, are recognized as them.
+const idioms = `
+map f xs = if isnil xs then nil else f (car xs) : map f (cdr xs)
+filter p xs = if isnil xs then nil else if p (car xs) then car xs : filter p (cdr xs) else filter p (cdr xs)
+foldl f z xs = if isnil xs then z else foldl f (f z (car xs)) (cdr xs)
+foldr f z xs = if isnil xs then z else f (car xs) (foldr f z (cdr xs))
+append xs ys = if isnil xs then ys else car xs : append (cdr xs) ys
+length xs = if isnil xs then 0 else 1 + length (cdr xs)
+index xs n = if 0 == n then car xs else index (cdr xs) (-1 + n)
+max x y = if x < y then y else x
+min x y = if x < y then x else y
+`
+
+// idiom is the term of an idiom, with its variables.
+type idiom struct {
+	name   string
+	self   string // Name that the idiom refers to itself by.
+	params []int
+	body   *term
+}
+
+var (
+	idiomsOnce     sync.Once
+	compiledIdioms []*idiom
+)
+
+// parseIdioms compiles and decompiles the idioms.
+func parseIdioms() []*idiom {
+	idiomsOnce.Do(func() {
+		program, err := compile.Compile(strings.NewReader(idioms), compile.Config{First: 1})
+		if err != nil {
+			// TestIdioms makes sure this doesn't happen.
+			return
+		}
+		for _, line := range strings.Split(strings.TrimSpace(idioms), "\n") {
+			name := strings.Fields(line)[0]
+			i := &idiom{name: name, self: program.Names[name]}
+			i.params, i.body = params(decompile(program.Vars, i.self))
+			compiledIdioms = append(compiledIdioms, i)
+		}
+	})
+	return compiledIdioms
+}
+
+// params returns the variables of the lambdas at the top of e, and their body.
+func params(e *term) ([]int, *term) {
+	var vars []int
+	for ; e.termType == lambdaTerm; e = e.fun {
+		vars = append(vars, e.id)
+	}
+	return vars, e
+}
+
+// recognize returns the idiom that the definition of self with params and body
+// is, and the params in the order of the idiom's.
+func recognize(self string, params []int, body *term) (*idiom, []int) {
+	for _, i := range parseIdioms() {
+		if len(i.params) != len(params) {
+			continue
+		}
+		for _, order := range permutations(len(params)) {
+			m := &matcher{self: self, idiom: i, order: order, vars: make(map[int]int)}
+			args := make([]int, len(params))
+			for pos, id := range i.params {
+				args[pos] = params[order[pos]]
+				m.vars[id] = args[pos]
+			}
+			if m.match(i.body, body) {
+				return i, args
+			}
+		}
+	}
+	return nil, nil
+}
+
+// permutations returns the orders of n items.
+func permutations(n int) [][]int {
+	if n == 0 {
+		return [][]int{{}}
+	}
+	var orders [][]int
+	for _, order := range permutations(n - 1) {
+		for pos := 0; pos < n; pos += 1 {
+			orders = append(orders, append(append(append([]int{}, order[:pos]...), n-1), order[pos:]...))
+		}
+	}
+	return orders
+}
+
+// matcher compares the body of an idiom with that of a definition.
+type matcher struct {
+	self  string
+	idiom *idiom
+	order []int       // Positions of the definition's params, in the order of the idiom's.
+	vars  map[int]int // Variables of the definition by those of the idiom.
+}
+
+func (m *matcher) match(x, y *term) bool {
+	switch x.termType {
+	case varTerm:
+		return y.termType == varTerm && y.id == m.vars[x.id]
+	case lambdaTerm:
+		if y.termType != lambdaTerm {
+			return false
+		}
+		m.vars[x.id] = y.id
+		return m.match(x.fun, y.fun)
+	case apTerm:
+		// Recursive calls pass the arguments in the order of the params.
+		if head, args := spine(x); head.termType == refTerm && len(args) == len(m.order) {
+			yHead, yArgs := spine(y)
+			if !m.match(head, yHead) || len(yArgs) != len(args) {
+				return false
+			}
+			for pos, arg := range args {
+				if !m.match(arg, yArgs[m.order[pos]]) {
+					return false
+				}
+			}
+			return true
+		}
+		return y.termType == apTerm && m.match(x.fun, y.fun) && m.match(x.arg, y.arg)
+	case refTerm:
+		return x.name == m.idiom.self && y.termType == refTerm && y.name == m.self
+	}
+	return x.termType == y.termType && x.name == y.name
+}
@@ -0,0 +1,341 @@
+package decompile
+
+import (
+	"app/eval"
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Precedences of the forms printed, higher binding tighter.
+const (
+	blockLevel = iota // Lambdas and ifs, which extend as far as possible.
+	compareLevel
+	consLevel
+	sumLevel
+	productLevel
+	unaryLevel
+	apLevel
+	atomLevel
+)
+
+// operators are the builtins printed between their two arguments.
+var operators = map[string]struct {
+	symbol string
+	level  int
+}{
+	"eq": {"==", compareLevel}, "lt": {"<", compareLevel}, "le": {"<=", compareLevel}, "cons": {":", consLevel},
+	"add": {"+", sumLevel}, "mul": {"*", productLevel}, "div": {"/", productLevel},
+}
+
+// variableNames are given to variables in turn, and again with a number.
+var variableNames = []string{"x", "y", "z", "u", "v", "w"}
+
+type printer struct {
+	out    strings.Builder
+	names  map[int]string  // Of the variables in scope.
+	used   map[string]bool // Names of the variables in scope.
+	indent string          // Of the lines of else branches.
+}
+
+// bind names the variable id, which comes into scope.
+func (p *printer) bind(id int) string {
+	for i := 0; ; i += 1 {
+		name := variableNames[i%len(variableNames)]
+		if i >= len(variableNames) {
+			name += fmt.Sprint(i / len(variableNames))
+		}
+		if !p.used[name] {
+			p.names[id] = name
+			p.used[name] = true
+			return name
+		}
+	}
+}
+
+func (p *printer) unbind(id int) {
+	delete(p.used, p.names[id])
+	delete(p.names, id)
+}
+
+// name returns the name that a builtin or definition is printed with.
+func name(e *term) string {
+	if e.termType == refTerm && strings.HasPrefix(e.name, ":") {
+		return "_" + e.name[1:]
+	}
+	return e.name
+}
+
+// equal reports whether x and y are the same term.
+func equal(x, y *term) bool {
+	if x.termType != y.termType || x.name != y.name || x.id != y.id {
+		return false
+	}
+	if x.termType == apTerm && !equal(x.arg, y.arg) {
+		return false
+	}
+	return x.fun == nil || equal(x.fun, y.fun)
+}
+
+// lessOrEqual turns 'lt x y (lt x y) (eq x y)', which is true if either is,
+// and 'lt x (inc y)', which the compile package makes, into 'le x y'.
+func lessOrEqual(head *term, args []*term) (*term, []*term) {
+	if !head.isFun("lt") || len(args) < 2 {
+		return head, args
+	}
+	if inc, incArgs := spine(args[1]); inc.isFun("inc") && len(incArgs) == 1 {
+		return newFun("le"), append([]*term{args[0], incArgs[0]}, args[2:]...)
+	}
+	if len(args) < 4 || !equal(args[2], newAp(head, args[:2]...)) {
+		return head, args
+	}
+	if eq, eqArgs := spine(args[3]); eq.isFun("eq") && len(eqArgs) == 2 &&
+		(equal(eqArgs[0], args[0]) && equal(eqArgs[1], args[1]) || equal(eqArgs[0], args[1]) && equal(eqArgs[1], args[0])) {
+		return newFun("le"), append(args[:2:2], args[4:]...)
+	}
+	return head, args
+}
+
+// condition returns the condition that head applied to args tests, and the
+// arguments that are the branches of an if, if head is a comparison.
+func condition(head *term, args []*term) (*term, []*term, bool) {
+	n := 0
+	switch {
+	case head.isFun("eq") || head.isFun("lt") || head.isFun("le"):
+		n = 2
+	case head.isFun("isnil") || head.isFun("if0"):
+		n = 1
+	}
+	if n == 0 || len(args) < n+2 {
+		return nil, nil, false
+	}
+	cond := newAp(head, args[:n]...)
+	if head.isFun("if0") {
+		cond = newAp(newFun("eq"), args[0], &term{termType: numTerm, name: "0"})
+	}
+	return cond, args[n:], true
+}
+
+// items returns the items of a list that ends with nil.
+func items(e *term) ([]*term, bool) {
+	var list []*term
+	for {
+		head, args := spine(e)
+		if head.isFun("nil") && len(args) == 0 {
+			return list, true
+		}
+		if !head.isFun("cons") || len(args) != 2 {
+			return nil, false
+		}
+		list = append(list, args[0])
+		e = args[1]
+	}
+}
+
+// print prints e, in parentheses unless it binds at least as tightly as level.
+func (p *printer) print(e *term, level int) {
+	head, args := lessOrEqual(spine(e))
+	if cond, branches, ok := condition(head, args); ok {
+		p.parenthesize(level, blockLevel, func() {
+			p.printIf(cond, branches[0], branches[1])
+		}, branches[2:])
+		return
+	}
+	if op, ok := operators[head.name]; ok && head.termType == funTerm && len(args) >= 2 {
+		if _, ok := items(e); ok && head.isFun("cons") && len(args) == 2 {
+			op.level = atomLevel
+		}
+		p.parenthesize(level, op.level, func() {
+			p.printOperator(head.name, args[0], args[1])
+		}, args[2:])
+		return
+	}
+	if head.isFun("neg") && len(args) >= 1 {
+		p.parenthesize(level, unaryLevel, func() {
+			p.out.WriteString("-")
+			p.print(args[0], unaryLevel)
+		}, args[1:])
+		return
+	}
+	if len(args) > 0 {
+		p.parenthesize(level, apLevel, func() {
+			p.print(head, apLevel)
+		}, args)
+		return
+	}
+	switch e.termType {
+	case lambdaTerm:
+		p.parenthesize(level, blockLevel, func() {
+			p.out.WriteString(`\`)
+			var bound []int
+			for ; e.termType == lambdaTerm; e = e.fun {
+				p.out.WriteString(p.bind(e.id) + " ")
+				bound = append(bound, e.id)
+			}
+			p.out.WriteString("-> ")
+			p.print(e, blockLevel)
+			for _, id := range bound {
+				p.unbind(id)
+			}
+		}, nil)
+	case varTerm:
+		if name, ok := p.names[e.id]; ok {
+			p.out.WriteString(name)
+		} else {
+			fmt.Fprintf(&p.out, "#%v", e.id)
+		}
+	case numTerm:
+		if strings.HasPrefix(e.name, "-") && level > unaryLevel {
+			fmt.Fprintf(&p.out, "(%v)", e.name)
+		} else {
+			p.out.WriteString(e.name)
+		}
+	default:
+		p.out.WriteString(name(e))
+	}
+}
+
+// parenthesize prints a form of the given level with printForm, applied to
+// args, in parentheses if it binds less tightly than level.
+func (p *printer) parenthesize(level, formLevel int, printForm func(), args []*term) {
+	outer := formLevel
+	if len(args) > 0 {
+		outer = apLevel
+	}
+	if outer < level {
+		p.out.WriteString("(")
+		defer p.out.WriteString(")")
+	}
+	if len(args) > 0 && formLevel < apLevel {
+		p.out.WriteString("(")
+		printForm()
+		p.out.WriteString(")")
+	} else {
+		printForm()
+	}
+	for _, arg := range args {
+		p.out.WriteString(" ")
+		p.print(arg, atomLevel)
+	}
+}
+
+// printOperator prints the builtin op between x and y, with lists in brackets
+// and additions of negations as subtractions.
+func (p *printer) printOperator(op string, x, y *term) {
+	if op == "cons" {
+		if list, ok := items(y); ok {
+			p.out.WriteString("[")
+			for pos, item := range append([]*term{x}, list...) {
+				if pos > 0 {
+					p.out.WriteString(", ")
+				}
+				p.print(item, blockLevel)
+			}
+			p.out.WriteString("]")
+			return
+		}
+		p.print(x, consLevel+1)
+		p.out.WriteString(" : ")
+		p.print(y, consLevel)
+		return
+	}
+	symbol, level := operators[op].symbol, operators[op].level
+	if op == "add" && (isNegative(x) && !isNegative(y)) {
+		x, y = y, x
+	}
+	if head, args := spine(y); op == "add" && head.isFun("neg") && len(args) == 1 {
+		symbol, y = "-", args[0]
+	} else if op == "add" && y.termType == numTerm && strings.HasPrefix(y.name, "-") {
+		symbol, y = "-", &term{termType: numTerm, name: y.name[1:]}
+	}
+	if level == compareLevel {
+		p.print(x, level+1)
+	} else {
+		p.print(x, level)
+	}
+	fmt.Fprintf(&p.out, " %v ", symbol)
+	p.print(y, level+1)
+}
+
+// isNegative reports whether e is a negative number or a negation.
+func isNegative(e *term) bool {
+	head, args := spine(e)
+	return e.termType == numTerm && strings.HasPrefix(e.name, "-") || head.isFun("neg") && len(args) == 1
+}
+
+// printIf prints 'if cond then x else y', with the ifs in y on lines of their
+// own. Branches that repeat the condition are printed as the boolean they are.
+func (p *printer) printIf(cond, x, y *term) {
+	if x.isFun("t") && y.isFun("f") {
+		p.print(cond, blockLevel)
+		return
+	}
+	indent := p.indent
+	p.indent += "  "
+	p.out.WriteString("if ")
+	p.print(cond, blockLevel)
+	p.out.WriteString(" then ")
+	p.printBranch(cond, x, "t")
+	chained := false
+	for {
+		nextCond, branches, ok := condition(lessOrEqual(spine(y)))
+		if !ok || len(branches) != 2 || branches[0].isFun("t") && branches[1].isFun("f") {
+			break
+		}
+		p.out.WriteString("\n" + p.indent + "else if ")
+		p.print(nextCond, blockLevel)
+		p.out.WriteString(" then ")
+		p.printBranch(nextCond, branches[0], "t")
+		cond, y = nextCond, branches[1]
+		chained = true
+	}
+	if chained {
+		p.out.WriteString("\n" + p.indent + "else ")
+	} else {
+		p.out.WriteString(" else ")
+	}
+	p.printBranch(cond, y, "f")
+	p.indent = indent
+}
+
+// printBranch prints a branch of an if on cond, or value if it is cond.
+func (p *printer) printBranch(cond, branch *term, value string) {
+	if equal(cond, branch) {
+		branch = newFun(value)
+	}
+	p.print(branch, blockLevel)
+}
+
+// Definition returns the pseudo-Haskell for the definition of name in defs,
+// such as '_1141 x y = if isnil x then y else ...'. Definitions recognized as
+// idioms are preceded by a comment such as '-- map y x'.
+func Definition(defs map[string]*eval.Node, name string) string {
+	p := &printer{names: make(map[int]string), used: make(map[string]bool), indent: "  "}
+	vars, body := params(decompile(defs, name))
+	p.out.WriteString(strings.Replace(name, ":", "_", 1))
+	for _, id := range vars {
+		p.out.WriteString(" " + p.bind(id))
+	}
+	p.out.WriteString(" = ")
+	p.print(body, blockLevel)
+	if idiom, args := recognize(name, vars, body); idiom != nil {
+		comment := "-- " + idiom.name
+		for _, id := range args {
+			comment += " " + p.names[id]
+		}
+		return comment + "\n" + p.out.String()
+	}
+	return p.out.String()
+}
+
+// WriteDefinitions writes the pseudo-Haskell for vars, in the order of
+// eval.DefinitionNames.
+func WriteDefinitions(w io.Writer, vars map[string]*eval.Node) error {
+	out := bufio.NewWriter(w)
+	for _, name := range eval.DefinitionNames(vars) {
+		out.WriteString(Definition(vars, name))
+		out.WriteString("\n")
+	}
+	return out.Flush()
+}
@@ -0,0 +1,118 @@
+package eval
+
+import "fmt"
+
+// errorPrint renders the terms embedded in errors. Terms in galaxy can be huge,
+// so only their top is shown.
+var errorPrint = PrintOptions{MaxDepth: 4}
+
+// ParseError points at the token a parse failed on, or at the end of the
+// input.
+type ParseError struct {
+	Pos   Pos
+	Token string // Empty at the end of the input.
+	Msg   string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Pos, e.Msg)
+}
+
+// Where describes the point of a reduction at which an error occurred.
+type Where struct {
+	Term string // Short rendering of the offending term.
+	Step int    // Number of steps taken so far.
+	Def  string // Top-level definition the term came from, if known.
+	Pos  Pos    // Where the term was parsed from, if known.
+}
+
+func (w Where) String() string {
+	s := fmt.Sprintf("step %v", w.Step)
+	if w.Def != "" {
+		s += fmt.Sprintf(" in %v", w.Def)
+	}
+	if w.Pos.IsKnown() {
+		s += fmt.Sprintf(" at %v", w.Pos)
+	}
+	return s
+}
+
+func (r *Reducer) where(n *Node) Where {
+	return Where{Term: errorPrint.Sprint(n), Step: r.stepCount, Def: n.def, Pos: n.pos}
+}
+
+// TypeError is returned when a builtin gets an argument it can't work with.
+type TypeError struct {
+	Where
+	Builtin  string
+	Expected string // Description of the expected argument.
+}
+
+func (e *TypeError) Error() string {
+	return fmt.Sprintf("'%v' expects %v: %v (%v)", e.Builtin, e.Expected, e.Term, e.Where)
+}
+
+// UnknownFunctionError is returned for applications of unimplemented builtins.
+type UnknownFunctionError struct {
+	Where
+	Builtin string
+}
+
+func (e *UnknownFunctionError) Error() string {
+	return fmt.Sprintf("unknown function '%v': %v (%v)", e.Builtin, e.Term, e.Where)
+}
+
+// UnknownRefError is returned for references to missing definitions.
+type UnknownRefError struct {
+	Where
+	Ref string
+}
+
+func (e *UnknownRefError) Error() string {
+	return fmt.Sprintf("unknown id '%v' (%v)", e.Ref, e.Where)
+}
+
+// StepLimitError is returned when a reduction takes more than MaxStepCount steps.
+type StepLimitError struct {
+	Where
+	MaxStepCount int
+}
+
+func (e *StepLimitError) Error() string {
+	return fmt.Sprintf("reached max step count %v: %v (%v)", e.MaxStepCount, e.Term, e.Where)
+}
+
+// LoopError is returned for a term whose value is the term itself, as for
+// ':1 = :1'.
+type LoopError struct {
+	Where
+}
+
+func (e *LoopError) Error() string {
+	return fmt.Sprintf("term depends on its own value: %v (%v)", e.Term, e.Where)
+}
+
+// MalformedError is returned for nodes that don't have the shape the Reducer
+// relies on, e.g. an application without an argument.
+type MalformedError struct {
+	Where
+	Reason string
+}
+
+func (e *MalformedError) Error() string {
+	return fmt.Sprintf("%v: %v (%v)", e.Reason, e.Term, e.Where)
+}
+
+// SendError is returned when 'send' fails to deliver data to the aliens.
+type SendError struct {
+	Where
+	Err error
+}
+
+func (e *SendError) Error() string {
+	return fmt.Sprintf("failed to send %v: %v (%v)", e.Term, e.Err, e.Where)
+}
+
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
@@ -1,12 +1,13 @@
 package eval
 
 import (
+	"context"
 	"errors"
-	"flag"
 	"fmt"
-	"log"
-	"math/bits"
+	"io"
+	"math/big"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -21,114 +22,319 @@ const (
 	Cons
 	Closure
 	Ref
+	Ind // Indirection to the result of a reduced node.
+	Pic // A Picture.
+	Var // Variable of an enclosing Lambda, by de Bruijn index.
 )
 
 type Node struct {
-	fun       *Node
+	fun       *Node // Function of an Ap, body of a Lambda, target of an Ind or a resolved Ref.
 	Nodes     []*Node
 	nodeType  NodeType
 	funName   string
 	num       int64
-	bound     string // Lambda-bound reference.
-	modulated string // 0s and 1s
+	big       *big.Int // Set instead of num for values that overflow int64.
+	bound     string   // Name of a Lambda's variable, only for printing. Made up if empty.
+	free      int64    // Number of enclosing lambdas the node's variables need. Zero if closed.
+	modulated string   // 0s and 1s
+	def       string   // Top-level definition the node was instantiated from.
+	picture   *Picture
+	pos       Pos // Position of the node's token, if it was parsed.
 }
 
-func (n *Node) Clone() *Node {
-	if n == nil {
-		return nil
+func NewFun(name string) *Node {
+	return &Node{nodeType: Fun, funName: name}
+}
+
+func NewAp(fun, arg *Node) *Node {
+	return (&Node{nodeType: Ap, fun: fun, Nodes: []*Node{arg}}).withFree()
+}
+
+// NewList returns a nil-terminated list of items.
+func NewList(items ...*Node) *Node {
+	list := NewFun("nil")
+	for pos := len(items) - 1; pos >= 0; pos -= 1 {
+		list = NewCons(items[pos], list)
+	}
+	return list
+}
+
+// NewCons returns the pair of head and tail, which is a vector for two numbers.
+func NewCons(head, tail *Node) *Node {
+	return (&Node{nodeType: Cons, Nodes: []*Node{head, tail}}).withFree()
+}
+
+// NewVar returns the variable of the index-th enclosing Lambda, counting from
+// 0 for the innermost one.
+func NewVar(index int64) *Node {
+	return &Node{nodeType: Var, num: index, free: index + 1}
+}
+
+// NewLambda returns a function of the variable NewVar(0) in body. The name of
+// the variable is only used for printing.
+func NewLambda(name string, body *Node) *Node {
+	return (&Node{nodeType: Lambda, fun: body, bound: name}).withFree()
+}
+
+// newClosure returns the application of a builtin to all its arguments.
+func newClosure(name string, args ...*Node) *Node {
+	return (&Node{nodeType: Closure, funName: name, Nodes: args}).withFree()
+}
+
+// withFree sets n.free from its children and returns n.
+func (n *Node) withFree() *Node {
+	n.free = 0
+	switch n.nodeType {
+	case Var:
+		n.free = n.num + 1
+		return n
+	case Ref:
+		// Resolved references lead to closed definitions.
+		return n
+	}
+	if n.fun != nil {
+		n.free = n.fun.free
+		if n.nodeType == Lambda && n.free > 0 {
+			n.free -= 1
+		}
 	}
-	clone := &Node{fun: n.fun.Clone(), nodeType: n.nodeType, funName: n.funName, num: n.num, bound: n.bound}
-	for _, node := range n.Nodes {
-		clone.Nodes = append(clone.Nodes, node.Clone())
+	for _, child := range n.Nodes {
+		if child != nil && child.free > n.free {
+			n.free = child.free
+		}
 	}
-	return clone
+	return n
 }
 
-// Instantiate returns a selective copy where the lambda variable has been replaced.
-func (n *Node) Instantiate(ref string, sub *Node) *Node {
-	//log.Printf("Instantiate called on %v  with  %v  ->  %v", n, ref, sub)
-	if n == nil {
+// NewRef returns a reference to the top-level definition id.
+func NewRef(id string) *Node {
+	return &Node{nodeType: Ref, funName: id}
+}
+
+// Pos returns where n was parsed from. Results of reduction take the position
+// of the term they were reduced from.
+func (n *Node) Pos() Pos {
+	return n.pos
+}
+
+// value skips the indirections left behind by reduction. On a cycle of them it
+// stops at some node of the cycle, as Reduce reports those with a LoopError.
+func (n *Node) value() *Node {
+	var mark *Node
+	hops, limit := 0, 1
+	for n != nil && (n.nodeType == Ind || n.nodeType == Ref && n.fun != nil) {
+		n = n.fun
+		if n == mark {
+			break
+		}
+		hops += 1
+		if hops == limit {
+			mark, hops, limit = n, 0, limit*2
+		}
+	}
+	return n
+}
+
+func (n *Node) IsNil() bool {
+	n = n.value()
+	return n != nil && n.nodeType == Fun && n.funName == "nil"
+}
+
+// Int64 returns the value of a Num node that fits into an int64.
+func (n *Node) Int64() (int64, bool) {
+	n = n.value()
+	if n == nil || n.nodeType != Num || n.big != nil {
+		return 0, false
+	}
+	return n.num, true
+}
+
+// Pair returns the head and tail of a Cons node.
+func (n *Node) Pair() (head, tail *Node, ok bool) {
+	n = n.value()
+	if n == nil || n.nodeType != Cons || len(n.Nodes) != 2 {
+		return nil, nil, false
+	}
+	return n.Nodes[0].value(), n.Nodes[1].value(), true
+}
+
+// Items returns the items of a reduced nil-terminated list.
+func (n *Node) Items() ([]*Node, bool) {
+	var items []*Node
+	for !n.IsNil() {
+		head, tail, ok := n.Pair()
+		if !ok {
+			return nil, false
+		}
+		items = append(items, head)
+		n = tail
+	}
+	return items, true
+}
+
+// Type returns the type of n, looking through indirections and resolved
+// references.
+func (n *Node) Type() NodeType {
+	return n.value().nodeType
+}
+
+// Name returns the name of a Fun or Ref node, or of a Lambda's variable.
+func (n *Node) Name() string {
+	n = n.value()
+	if n.nodeType == Lambda {
+		return n.bound
+	}
+	return n.funName
+}
+
+// Fun returns the function of an Ap node or the body of a Lambda node.
+func (n *Node) Fun() *Node {
+	n = n.value()
+	if n.nodeType != Ap && n.nodeType != Lambda {
 		return nil
 	}
-	type queuedNode struct {
-		path      []int
-		pathNodes []*Node
-		node      *Node
+	return n.fun
+}
+
+// Index returns the de Bruijn index of a Var node.
+func (n *Node) Index() (int64, bool) {
+	n = n.value()
+	if n == nil || n.nodeType != Var {
+		return 0, false
 	}
-	queue := []queuedNode{{node: n}}
-	var refItem queuedNode
-	for len(queue) > 0 {
-		item := queue[0]
-		queue = queue[1:]
-		if item.node.nodeType == Ref && item.node.funName == ref {
-			refItem = item
-			break
+	return n.num, true
+}
+
+// Clone returns a deep copy of n. Nodes shared within n are shared in the copy.
+func (n *Node) Clone() *Node {
+	return n.clone(make(map[*Node]*Node))
+}
+
+func (n *Node) clone(clones map[*Node]*Node) *Node {
+	shallow := func(n *Node) (*Node, bool) {
+		if n == nil {
+			return nil, false
 		}
-		for pos, child := range item.node.Nodes {
-			childItem := queuedNode{node: child}
-			childItem.path = append(item.path, pos)
-			childItem.pathNodes = append(item.pathNodes, item.node)
-			queue = append(queue, childItem)
+		if clone, ok := clones[n]; ok {
+			return clone, false
 		}
-		if item.node.fun != nil {
-			childItem := queuedNode{node: item.node.fun}
-			childItem.path = append(item.path, -1)
-			childItem.pathNodes = append(item.pathNodes, item.node)
-			queue = append(queue, childItem)
+		clone := &Node{nodeType: n.nodeType, funName: n.funName, num: n.num, big: n.big, bound: n.bound,
+			free: n.free, modulated: n.modulated, picture: n.picture, pos: n.pos}
+		clones[n] = clone
+		return clone, true
+	}
+	root, isNew := shallow(n)
+	if !isNew {
+		return root
+	}
+	// Nodes are copied before their children, which are filled in from a stack.
+	stack := []*Node{n}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		clone := clones[node]
+		if node.nodeType != Ref {
+			// A clone of a Ref is left unresolved.
+			fun, isNew := shallow(node.fun)
+			clone.fun = fun
+			if isNew {
+				stack = append(stack, node.fun)
+			}
+		}
+		for _, child := range node.Nodes {
+			childClone, isNew := shallow(child)
+			clone.Nodes = append(clone.Nodes, childClone)
+			if isNew {
+				stack = append(stack, child)
+			}
 		}
 	}
-	if refItem.node == nil {
-		//log.Println("WTF?")
-		return n
+	return root
+}
+
+// Instantiate returns the body n of a Lambda with its variable replaced by sub,
+// which must be closed. Only the nodes with free variables are copied, so the
+// rest stays shared.
+func (n *Node) Instantiate(sub *Node) *Node {
+	type task struct {
+		slot  **Node // Where the node to instantiate is, and its copy goes.
+		depth int64  // Number of lambdas between the body and the node.
 	}
-	path := refItem.path
-	pathNodes := refItem.pathNodes
-	clone := sub
-	for len(pathNodes) > 0 {
-		parentNode := pathNodes[len(pathNodes)-1]
-		childPos := path[len(path)-1]
-		parentClone := &Node{
-			nodeType: parentNode.nodeType, funName: parentNode.funName, num: parentNode.num, bound: parentNode.bound}
-		for pos, child := range parentNode.Nodes {
-			if childPos == pos {
-				parentClone.Nodes = append(parentClone.Nodes, clone)
+	result := n
+	tasks := []task{{&result, 0}}
+	var copies []*Node
+	for len(tasks) > 0 {
+		t := tasks[len(tasks)-1]
+		tasks = tasks[:len(tasks)-1]
+		node := *t.slot
+		if node == nil || node.free <= t.depth {
+			// Nothing in the node refers to the variable.
+			continue
+		}
+		if node.nodeType == Var {
+			if node.num == t.depth {
+				*t.slot = sub
 			} else {
-				parentClone.Nodes = append(parentClone.Nodes, child)
+				// A variable of a lambda further out, which is one closer now.
+				*t.slot = NewVar(node.num - 1)
 			}
+			continue
 		}
-		if childPos == -1 {
-			parentClone.fun = clone
-		} else {
-			parentClone.fun = parentNode.fun
+		clone := *node
+		clone.Nodes = append([]*Node(nil), node.Nodes...)
+		*t.slot = &clone
+		copies = append(copies, &clone)
+		depth := t.depth
+		if node.nodeType == Lambda {
+			depth += 1
+		}
+		tasks = append(tasks, task{&clone.fun, depth})
+		for pos := range clone.Nodes {
+			tasks = append(tasks, task{&clone.Nodes[pos], depth})
 		}
-		clone = parentClone
-		path = path[0 : len(path)-1]
-		pathNodes = pathNodes[0 : len(pathNodes)-1]
 	}
-	//log.Printf("Instantiated: %v", clone)
-	return clone
+	// Copies come after their parents, so going backwards updates children first.
+	for pos := len(copies) - 1; pos >= 0; pos -= 1 {
+		copies[pos].withFree()
+	}
+	return result
 }
 
-var visited = make(map[*Node]bool)
-var topCall = true
-var printAddr = flag.Bool("print_expr_addr", false,
-	"Print address of expression Nodes.")
-var ShowSharing = flag.Bool("show_expr_sharing", false,
-	"Print address of expression Nodes.")
+// PrintOptions controls how Nodes are rendered as text.
+type PrintOptions struct {
+	ShowAddr    bool // Print address of expression Nodes.
+	ShowSharing bool // Print shared Nodes only once, by address afterwards.
+	MaxDepth    int  // Print subtrees deeper than this as "...". Zero means no limit.
+}
+
+// printer renders a single tree. It's created per call, so rendering is safe
+// to do from several goroutines.
+type printer struct {
+	PrintOptions
+	visited map[*Node]bool
+	depth   int
+	binders []string // Names of the variables of the enclosing lambdas.
+}
+
+// Sprint renders n using the options in o.
+func (o PrintOptions) Sprint(n *Node) string {
+	p := &printer{PrintOptions: o}
+	if o.ShowSharing {
+		p.visited = make(map[*Node]bool)
+	}
+	return p.sprint(n)
+}
 
 func (n *Node) String() string {
-	if *ShowSharing {
-		if topCall {
-			visited = make(map[*Node]bool)
-			topCall = false
-			defer func() {
-				topCall = true
-			}()
-		}
-		if visited[n] {
+	return PrintOptions{}.Sprint(n)
+}
+
+func (p *printer) sprint(n *Node) string {
+	if p.ShowSharing {
+		if p.visited[n] {
 			return fmt.Sprintf("{%p}", n)
 		}
-		visited[n] = true
+		p.visited[n] = true
 	}
 	if n == nil {
 		return "<nil>"
@@ -136,36 +342,64 @@ func (n *Node) String() string {
 	if n.modulated != "" {
 		return n.modulated
 	}
+	if p.MaxDepth > 0 && (n.nodeType == Ap || n.nodeType == Lambda || n.nodeType == Cons || n.nodeType == Closure) {
+		if p.depth >= p.MaxDepth {
+			return "..."
+		}
+		p.depth += 1
+		defer func() {
+			p.depth -= 1
+		}()
+	}
 	switch n.nodeType {
 	case Ref:
 		return fmt.Sprintf("%v", n.funName)
+	case Ind:
+		return p.sprint(n.fun)
 	case Num:
+		if n.big != nil {
+			return n.big.String()
+		}
 		return fmt.Sprintf("%v", n.num)
 	case Fun:
 		return n.funName
+	case Pic:
+		return n.picture.String()
+	case Var:
+		if n.num < int64(len(p.binders)) {
+			return p.binders[int64(len(p.binders))-1-n.num]
+		}
+		// Free variables are numbered as seen from outside the printed term.
+		return fmt.Sprintf("#%v", n.num-int64(len(p.binders)))
 	case Lambda:
-		//log.Printf("Printing lambda with bound '%v'", n.bound)
-		if *printAddr {
-			return fmt.Sprintf("%p|(%v.%v)", n, n.bound, n.fun)
+		// Made up names go by depth, so the same lambda always prints the same.
+		name := n.bound
+		if name == "" {
+			name = fmt.Sprint("X", len(p.binders))
+		}
+		p.binders = append(p.binders, name)
+		body := p.sprint(n.fun)
+		p.binders = p.binders[:len(p.binders)-1]
+		if p.ShowAddr {
+			return fmt.Sprintf("%p|(%v.%v)", n, name, body)
 		} else {
-			return fmt.Sprintf("(%v.%v)", n.bound, n.fun)
+			return fmt.Sprintf("(%v.%v)", name, body)
 		}
 	case Cons:
 		{
 			if len(n.Nodes) != 2 {
 				return fmt.Sprintf("<Corrupted CONS: %v node(s)>", len(n.Nodes))
 			} else {
-				return fmt.Sprintf("[ %v :: %v ]", n.Nodes[0], n.Nodes[1])
+				return fmt.Sprintf("[ %v :: %v ]", p.sprint(n.Nodes[0]), p.sprint(n.Nodes[1]))
 			}
 		}
 	case Closure:
 		{
-			//log.Printf("Printing closure with function '%v'", n.funName)
 			var args []string
 			for _, node := range n.Nodes {
-				args = append(args, fmt.Sprint(node))
+				args = append(args, p.sprint(node))
 			}
-			if *printAddr {
+			if p.ShowAddr {
 				return fmt.Sprintf("%p|%v(%v)", n, n.funName, strings.Join(args, ", "))
 			} else {
 				return fmt.Sprintf("%v(%v)", n.funName, strings.Join(args, ", "))
@@ -176,10 +410,10 @@ func (n *Node) String() string {
 			if len(n.Nodes) != 1 {
 				return fmt.Sprintf("<Corrupted AP: %v node(s)>", len(n.Nodes))
 			} else {
-				if *printAddr {
-					return fmt.Sprintf("%p|(%v %v)", n, n.fun, n.Nodes[0])
+				if p.ShowAddr {
+					return fmt.Sprintf("%p|(%v %v)", n, p.sprint(n.fun), p.sprint(n.Nodes[0]))
 				} else {
-					return fmt.Sprintf("(%v %v)", n.fun, n.Nodes[0])
+					return fmt.Sprintf("(%v %v)", p.sprint(n.fun), p.sprint(n.Nodes[0]))
 				}
 			}
 		}
@@ -188,6 +422,35 @@ func (n *Node) String() string {
 	}
 }
 
+// Refs returns the names of the definitions n refers to, sorted and without
+// duplicates.
+func (n *Node) Refs() []string {
+	seen := make(map[string]bool)
+	visited := make(map[*Node]bool)
+	stack := []*Node{n}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if node == nil || visited[node] {
+			continue
+		}
+		visited[node] = true
+		if node.nodeType == Ref {
+			// Resolved references lead into other definitions.
+			seen[node.funName] = true
+			continue
+		}
+		stack = append(stack, node.fun)
+		stack = append(stack, node.Nodes...)
+	}
+	refs := []string{}
+	for name := range seen {
+		refs = append(refs, name)
+	}
+	sort.Strings(refs)
+	return refs
+}
+
 // NodeCount() returns the size of the subtree rooted at n.
 func (n *Node) NodeCount() int {
 	visited := make(map[*Node]bool)
@@ -211,6 +474,8 @@ func (n *Node) NodeCount() int {
 	return len(visited)
 }
 
+// Parser reads definitions of the form 'name = expression' into Vars.
+// Definitions and the tokens within them may be separated by any whitespace.
 type Parser struct {
 	Vars           map[string]*Node
 	parsingVar     string
@@ -218,87 +483,218 @@ type Parser struct {
 	RecursiveCount int // Number of recursive definitions.
 }
 
-func (p *Parser) ParseAp(tokens []string, pos int) (*Node, []string, error) {
-	if len(tokens) == 0 {
-		return nil, nil, errors.New(fmt.Sprintf("out of tokens at %v", pos))
+// endOfInput returns the error for running out of tokens while expecting what.
+func endOfInput(t *Tokenizer, what string) error {
+	if last := t.Last(); last.Text != "" {
+		return &ParseError{Pos: t.Pos(), Msg: fmt.Sprintf("expected %v after '%v' at %v", what, last.Text, last.Pos)}
 	}
-	node := &Node{}
-	if fun, rem1, err := p.ParseExp(tokens, pos); err != nil {
-		return nil, rem1, err
-	} else {
-		node.fun = fun
-		if arg, rem2, err := p.ParseExp(rem1, pos+(len(tokens)-len(rem1))); err != nil {
-			return nil, rem2, err
-		} else {
-			node.Nodes = append(node.Nodes, arg)
-			return node, rem2, nil
+	return &ParseError{Pos: t.Pos(), Msg: fmt.Sprintf("expected %v", what)}
+}
+
+// ParseExpr reads an expression from t. Nodes keep the position of their token.
+// Applications and lambdas wait on a stack for their parts, so the depth of an
+// expression is only limited by memory.
+//
+// Besides 'ap', references, numbers and builtins, expressions may be lambdas
+// such as '\x -> ap ap add x 1', whose variables take precedence over builtins
+// of the same name.
+func (p *Parser) ParseExpr(t *Tokenizer) (*Node, error) {
+	var pending []*Node
+	var scope []string // Variables of the pending lambdas, innermost last.
+	for {
+		token, err := t.Next()
+		if err == io.EOF {
+			return nil, endOfInput(t, "expression")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if token.Text == "ap" {
+			pending = append(pending, &Node{nodeType: Ap, pos: token.Pos})
+			continue
+		}
+		if strings.HasPrefix(token.Text, `\`) {
+			name, err := parseLambdaHead(t, token)
+			if err != nil {
+				return nil, err
+			}
+			pending = append(pending, &Node{nodeType: Lambda, bound: name, pos: token.Pos})
+			scope = append(scope, name)
+			continue
+		}
+		node, err := p.parseAtom(token, scope)
+		if err != nil {
+			return nil, err
+		}
+		// Complete the applications that were only waiting for their argument,
+		// and the lambdas waiting for their body.
+		for {
+			if len(pending) == 0 {
+				return node, nil
+			}
+			top := pending[len(pending)-1]
+			if top.nodeType == Ap && top.fun == nil {
+				top.fun = node
+				break
+			}
+			if top.nodeType == Lambda {
+				top.fun = node
+				scope = scope[:len(scope)-1]
+			} else {
+				top.Nodes = []*Node{node}
+			}
+			pending = pending[:len(pending)-1]
+			node = top.withFree()
 		}
 	}
 }
 
-func (p *Parser) ParseExp(tokens []string, pos int) (*Node, []string, error) {
-	if len(tokens) == 0 {
-		return nil, nil, errors.New(fmt.Sprintf("out of tokens at %v", pos))
-	}
-	if tokens[0] == "ap" {
-		if node, rem, err := p.ParseAp(tokens[1:], pos+1); err != nil {
-			return nil, rem, err
-		} else {
-			return node, rem, nil
+// parseLambdaHead reads the variable and arrow of a lambda starting with head,
+// which is either '\x' or '\' followed by the variable.
+func parseLambdaHead(t *Tokenizer, head Token) (string, error) {
+	name := head.Text[1:]
+	if name == "" {
+		token, err := t.Next()
+		if err == io.EOF {
+			return "", endOfInput(t, "variable")
+		}
+		if err != nil {
+			return "", err
 		}
+		name = token.Text
+	}
+	if !isVariableName(name) {
+		return "", &ParseError{Pos: head.Pos, Token: head.Text, Msg: fmt.Sprintf("invalid variable name '%v'", name)}
+	}
+	arrow, err := t.Next()
+	if err == io.EOF {
+		return "", endOfInput(t, "'->'")
+	}
+	if err != nil {
+		return "", err
 	}
+	if arrow.Text != "->" {
+		return "", &ParseError{Pos: arrow.Pos, Token: arrow.Text,
+			Msg: fmt.Sprintf(`expected '->' after '\%v', got '%v'`, name, arrow.Text)}
+	}
+	return name, nil
+}
+
+// isVariableName reports whether name can't be mistaken for anything but a
+// variable.
+func isVariableName(name string) bool {
+	switch name {
+	case "", "ap", "=", "->":
+		return false
+	}
+	if strings.Contains(name, `\`) || strings.HasPrefix(name, ":") {
+		return false
+	}
+	_, ok := new(big.Int).SetString(name, 10)
+	return !ok
+}
+
+// parseAtom returns the node for a token other than "ap" or a lambda, looking
+// up variables in scope first.
+func (p *Parser) parseAtom(token Token, scope []string) (*Node, error) {
 	p.NodeCount += 1
-	if []rune(tokens[0])[0] == ':' {
-		if p.parsingVar == tokens[0] {
+	if token.Text == "=" || token.Text == "->" {
+		return nil, &ParseError{Pos: token.Pos, Token: token.Text, Msg: fmt.Sprintf("unexpected '%v'", token.Text)}
+	}
+	for pos := len(scope) - 1; pos >= 0; pos -= 1 {
+		if scope[pos] == token.Text {
+			node := NewVar(int64(len(scope) - 1 - pos))
+			node.pos = token.Pos
+			return node, nil
+		}
+	}
+	if []rune(token.Text)[0] == ':' {
+		if p.parsingVar == token.Text {
 			p.RecursiveCount += 1
 			p.parsingVar = ""
 		}
-		return &Node{nodeType: Ref, funName: tokens[0]}, tokens[1:], nil
+		return &Node{nodeType: Ref, funName: token.Text, pos: token.Pos}, nil
+	}
+	if num, err := strconv.ParseInt(token.Text, 10, 64); err == nil {
+		return &Node{nodeType: Num, num: num, pos: token.Pos}, nil
 	}
-	if num, err := strconv.ParseInt(tokens[0], 10, 64); err == nil {
-		return &Node{nodeType: Num, num: num}, tokens[1:], nil
+	if num, ok := new(big.Int).SetString(token.Text, 10); ok {
+		node := NewBigNum(num)
+		node.pos = token.Pos
+		return node, nil
 	}
 	// Otherwise it must be a function name.
-	return &Node{nodeType: Fun, funName: tokens[0]}, tokens[1:], nil
+	return &Node{nodeType: Fun, funName: token.Text, pos: token.Pos}, nil
 }
 
+// Parse reads the definitions in exp and returns the last one.
 func (p *Parser) Parse(exp string) (*Node, error) {
+	return p.ParseReader(strings.NewReader(exp))
+}
+
+// ParseReader reads definitions from r as it goes and returns the last one.
+func (p *Parser) ParseReader(r io.Reader) (*Node, error) {
 	p.Vars = make(map[string]*Node)
-	lines := strings.Split(exp, "\n")
+	t := NewTokenizer(r)
 	var lastNode *Node
-	for row, line := range lines {
-		if line == "" {
-			continue
+	for {
+		name, err := t.Next()
+		if err == io.EOF {
+			return lastNode, nil
+		}
+		if err != nil {
+			return nil, err
 		}
-		tokens := strings.Split(line, " ")
-		p.parsingVar = tokens[0]
-		if len(tokens) < 3 {
-			return nil, errors.New(fmt.Sprintf("line %v: not enough tokens: %v", row+1, line))
+		equals, err := t.Next()
+		if err == io.EOF {
+			return nil, endOfInput(t, "'='")
 		}
-		node, rem, err := p.ParseExp(tokens[2:], 2)
 		if err != nil {
-			return nil, errors.New(fmt.Sprintf("line %v: %v", row+1, err))
+			return nil, err
 		}
-		if len(rem) > 0 {
-			return nil, errors.New(fmt.Sprintf("line %v: unparsed leftover %v", row+1, rem))
+		if equals.Text != "=" {
+			return nil, &ParseError{Pos: equals.Pos, Token: equals.Text,
+				Msg: fmt.Sprintf("expected '=' after '%v', got '%v'", name.Text, equals.Text)}
 		}
-		p.Vars[tokens[0]] = node
+		p.parsingVar = name.Text
+		node, err := p.ParseExpr(t)
+		if err != nil {
+			return nil, err
+		}
+		p.Vars[name.Text] = node
 		lastNode = node
 	}
-	return lastNode, nil
 }
 
+// ReducerConfig holds the settings of a Reducer.
+type ReducerConfig struct {
+	MaxStepCount int          // Zero means no limit.
+	KeepSteps    bool         // Record the root after every step.
+	PrintSteps   bool         // Print the changes made by every step to stderr.
+	Print        PrintOptions // Used for recording and printing steps.
+	Sender       Sender       // Used by 'send'. Optional.
+}
+
+// Sender delivers data to the aliens and returns their response.
+type Sender interface {
+	Send(ctx context.Context, data *Node) (*Node, error)
+}
+
+// Reducer evaluates an expression. Its state is private to it, so Reducers
+// over the same Parser.Vars can run in parallel.
 type Reducer struct {
-	Root         *Node
-	steps        []string
-	stepCount    int
-	MaxStepCount int
-	keepSteps    bool
-	lambdas      int
-	vars         map[string]*Node
-	PrintSteps   bool
-	clones       int
-	prevStep     string
+	ReducerConfig
+	Root      *Node
+	steps     []string
+	stepCount int
+	vars      map[string]*Node
+	prevStep  string
+	cafs      map[string]*Node      // Shared instances of the top-level definitions.
+	CafHits   int                   // References resolved from the cache.
+	CafMisses int                   // Definitions instantiated for the first time.
+	ctx       context.Context       // Checked for cancellation while reducing, if set.
+	demanded  *Node                 // The subterm a step returning errDemand needs.
+	walks     map[**Node]*eagerWalk // Eager reductions in progress within steps.
 }
 
 func common(prev, next string) (pfx, changed, sfx string) {
@@ -335,11 +731,11 @@ func common(prev, next string) (pfx, changed, sfx string) {
 }
 
 func (r *Reducer) RecordStep() {
-	if r.keepSteps {
-		r.steps = append(r.steps, fmt.Sprint(r.Root))
+	if r.KeepSteps {
+		r.steps = append(r.steps, r.Print.Sprint(r.Root))
 	}
 	if r.PrintSteps {
-		visual := fmt.Sprint(r.Root)
+		visual := r.Print.Sprint(r.Root)
 		pfx, changed, sfx := common(r.prevStep, visual)
 		r.prevStep = visual
 		_, err := fmt.Fprintf(os.Stderr, "#%v  -->  %v%v%v\n\n", r.stepCount, pfx, changed, sfx)
@@ -350,38 +746,50 @@ func (r *Reducer) RecordStep() {
 }
 
 func (p *Parser) NewReducer(node *Node, keepSteps bool) *Reducer {
-	reducer := &Reducer{Root: node, keepSteps: keepSteps}
+	return p.NewReducerWithConfig(node, ReducerConfig{KeepSteps: keepSteps})
+}
+
+// NewReducerWithConfig returns a Reducer for a copy of node, leaving node and
+// the definitions in p.Vars untouched.
+func (p *Parser) NewReducerWithConfig(node *Node, config ReducerConfig) *Reducer {
+	reducer := &Reducer{ReducerConfig: config, Root: node.Clone()}
 	reducer.RecordStep()
 	reducer.vars = p.Vars
 	return reducer
 }
 
-func (r *Reducer) newVarName() string {
-	varName := fmt.Sprint("X", r.lambdas)
-	r.lambdas += 1
-	return varName
-}
-
-func modulate(num int64) string {
+func modulate(n *Node) string {
 	var bytes []byte
-	if num == 0 {
+	if n.isZero() {
 		return "010"
 	}
-	if num > 0 {
-		bytes = append(bytes, []byte("01")...)
+	var digits string
+	if n.big == nil {
+		magnitude := uint64(n.num)
+		if n.num > 0 {
+			bytes = append(bytes, []byte("01")...)
+		} else {
+			bytes = append(bytes, []byte("10")...)
+			magnitude = -magnitude
+		}
+		digits = strconv.FormatUint(magnitude, 2)
 	} else {
-		bytes = append(bytes, []byte("10")...)
-		num = -num
+		if n.big.Sign() > 0 {
+			bytes = append(bytes, []byte("01")...)
+		} else {
+			bytes = append(bytes, []byte("10")...)
+		}
+		digits = new(big.Int).Abs(n.big).Text(2)
 	}
-	bits4Needed := (64 - bits.LeadingZeros64(uint64(num)) + 3) / 4
+	bits4Needed := (len(digits) + 3) / 4
 	bytes = append(bytes, []byte(strings.Repeat("1", bits4Needed)+"0")...)
-	bytes = append(bytes, []byte(fmt.Sprintf("%0[1]*[2]b", bits4Needed*4, num))...)
+	bytes = append(bytes, []byte(strings.Repeat("0", bits4Needed*4-len(digits))+digits)...)
 	return string(bytes)
 }
 
-func demodulate(bytes []byte) (int64, []byte) {
+func demodulate(bytes []byte) (*Node, []byte) {
 	if len(bytes) < 3 {
-		return 0, nil
+		return NewNum(0), nil
 	}
 	pfx := string(bytes[:2])
 	sign := int64(1)
@@ -400,89 +808,124 @@ func demodulate(bytes []byte) (int64, []byte) {
 		}
 	}
 	if bits4Used == 0 {
-		return 0, bytes
+		return NewNum(0), bytes
+	}
+	if len(bytes) < 4*bits4Used {
+		return NewNum(0), nil
 	}
 	numStr := bytes[:4*bits4Used]
 	bytes = bytes[4*bits4Used:]
-	if num, err := strconv.ParseInt(string(numStr), 2, 64); err != nil {
-		return 0, nil
-	} else {
-		return num * sign, bytes
+	if 4*bits4Used < 64 {
+		if num, err := strconv.ParseInt(string(numStr), 2, 64); err == nil {
+			return NewNum(num * sign), bytes
+		}
+		return NewNum(0), nil
+	}
+	num, ok := new(big.Int).SetString(string(numStr), 2)
+	if !ok {
+		return NewNum(0), nil
 	}
+	if sign < 0 {
+		num.Neg(num)
+	}
+	return NewBigNum(num), bytes
 }
 
 func DemodulateList(bytes []byte) (*Node, []byte, error) {
-	if len(bytes) == 0 {
-		return nil, nil, errors.New("nothing to demodulate")
-	}
-	pfx := string(bytes[:2])
-	if pfx == "00" {
-		return &Node{nodeType: Fun, funName: "nil"}, bytes[2:], nil
-	}
-	if pfx == "01" || pfx == "10" {
-		var num int64
-		num, bytes = demodulate(bytes)
-		return &Node{nodeType: Num, num: num}, bytes, nil
-	}
-	head, bytes, err := DemodulateList(bytes[2:])
-	if err != nil {
-		return nil, bytes, err
+	// Cells wait on a stack for their head, then their tail.
+	var cells []*Node
+	for {
+		if len(bytes) < 2 {
+			return nil, nil, errors.New("nothing to demodulate")
+		}
+		var node *Node
+		switch pfx := string(bytes[:2]); pfx {
+		case "00":
+			node = &Node{nodeType: Fun, funName: "nil"}
+			bytes = bytes[2:]
+		case "01", "10":
+			node, bytes = demodulate(bytes)
+		case "11":
+			cells = append(cells, &Node{nodeType: Cons})
+			bytes = bytes[2:]
+			continue
+		default:
+			return nil, nil, errors.New(fmt.Sprintf("unexpected prefix: %q", pfx))
+		}
+		for {
+			if len(cells) == 0 {
+				return node, bytes, nil
+			}
+			cell := cells[len(cells)-1]
+			cell.Nodes = append(cell.Nodes, node)
+			if len(cell.Nodes) < 2 {
+				break
+			}
+			cells = cells[:len(cells)-1]
+			node = cell
+		}
 	}
-	tail, bytes, err := DemodulateList(bytes)
+}
+
+// Modem returns a copy of a reduced number or list after going through
+// modulation, which fails for anything else, as the aliens couldn't read it.
+func Modem(n *Node) (*Node, error) {
+	bytes, err := ModulateList(n, nil)
 	if err != nil {
-		return nil, bytes, err
+		return nil, err
 	}
-	return &Node{nodeType: Cons, Nodes: []*Node{head, tail}}, bytes, nil
+	modem, _, err := DemodulateList(bytes)
+	return modem, err
 }
 
 func ModulateList(n *Node, bytes []byte) ([]byte, error) {
-	if n == nil {
-		return nil, errors.New(fmt.Sprintf("can't modulate <nil>"))
-	}
-	if n.funName == "nil" {
-		return append(bytes, []byte("00")...), nil
-	}
-	if n.nodeType == Num {
-		return append(bytes, []byte(modulate(n.num))...), nil
-	}
-	if n.nodeType != Cons {
-		return nil, errors.New(fmt.Sprintf("expected Cons: %v", n))
-	}
-	bytes = append(bytes, []byte("11")...)
-	var err error
-	bytes, err = ModulateList(n.Nodes[0], bytes)
-	if err != nil {
-		return nil, err
+	// Heads are modulated before tails, so they go on top of the stack.
+	stack := []*Node{n}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1].value()
+		stack = stack[:len(stack)-1]
+		if n == nil {
+			return nil, errors.New(fmt.Sprintf("can't modulate <nil>"))
+		}
+		if n.funName == "nil" {
+			bytes = append(bytes, []byte("00")...)
+			continue
+		}
+		if n.nodeType == Num {
+			bytes = append(bytes, []byte(modulate(n))...)
+			continue
+		}
+		if n.nodeType != Cons {
+			return nil, errors.New(fmt.Sprintf("expected Cons: %v", n))
+		}
+		bytes = append(bytes, []byte("11")...)
+		stack = append(stack, n.Nodes[1], n.Nodes[0])
 	}
-	return ModulateList(n.Nodes[1], bytes)
+	return bytes, nil
 }
 
-func (r *Reducer) ReduceFunction(n *Node) (*Node, error) {
+// maxPwr2Exponent limits 'pwr2' to numbers of a few kilobytes.
+const maxPwr2Exponent = 65536
+
+// reduceFunction applies the builtin n.fun to its argument.
+func (r *Reducer) reduceFunction(n *Node) (*Node, error) {
 	if n.fun.nodeType != Fun {
-		return nil, errors.New(fmt.Sprintf("expected function node: %v", n))
+		return nil, &MalformedError{Where: r.where(n), Reason: "expected function node"}
 	}
 	if len(n.Nodes) != 1 {
-		return nil, errors.New(fmt.Sprintf("function node expects exactly one arg: %v", n))
+		return nil, &MalformedError{Where: r.where(n), Reason: "function node expects exactly one arg"}
 	}
 	switch n.fun.funName {
 	case "f": // First argument ignored.
 		n.Nodes[0] = &Node{nodeType: Fun, funName: "_"}
-	case "if0", "mod", "dem", "demlist", "neg", "inc", "dec", "isnil", "car", "cdr", "double":
+	case "if0", "mod", "dem", "demlist", "neg", "inc", "dec", "isnil", "car", "cdr", "double", "pwr2",
+		"multipledraw":
 		// Functions strict in first argument.
-		for {
-			if arg, err := r.Reduce(n.Nodes[0]); err != nil {
-				return nil, err
-			} else {
-				if n.Nodes[0] != arg {
-					n.Nodes[0] = arg
-					r.RecordStep()
-				} else {
-					break
-				}
-			}
+		if _, err := r.whnf(&n.Nodes[0]); err != nil {
+			return nil, err
 		}
-	case "modlist":
-		if _, err := r.EagerReduce(&n.Nodes[0]); err != nil {
+	case "modlist", "modem", "send", "draw":
+		if err := r.force(&n.Nodes[0]); err != nil {
 			return nil, err
 		}
 	}
@@ -491,39 +934,81 @@ func (r *Reducer) ReduceFunction(n *Node) (*Node, error) {
 		return &Node{nodeType: Fun, funName: "t"}, nil
 	case "modlist":
 		if n.Nodes[0].nodeType != Cons && n.Nodes[0].funName != "nil" {
-			return nil, errors.New(fmt.Sprintf("expected list argument: %v", n))
+			return nil, &TypeError{Where: r.where(n), Builtin: n.fun.funName, Expected: "a list"}
 		}
 		var bytes []byte
 		bytes, err := ModulateList(n.Nodes[0], bytes)
 		if err != nil {
-			return nil, errors.New(fmt.Sprintf("failed to modulate list: %v, error: %v", n, err))
+			return nil, &TypeError{Where: r.where(n), Builtin: n.fun.funName, Expected: "a list of numbers"}
 		}
 		return &Node{nodeType: Num, modulated: string(bytes)}, nil
 	case "demlist":
 		if n.Nodes[0].nodeType != Num || n.Nodes[0].modulated == "" {
-			return nil, errors.New(fmt.Sprintf("expected modulated list argument: %v", n))
+			return nil, &TypeError{Where: r.where(n), Builtin: n.fun.funName, Expected: "a modulated list"}
 		}
 		list, _, err := DemodulateList([]byte(n.Nodes[0].modulated))
 		if err != nil {
-			return nil, errors.New(fmt.Sprintf("failed to demodulate list: %v, error: %v", n, err))
+			return nil, &TypeError{Where: r.where(n), Builtin: n.fun.funName, Expected: "a modulated list"}
 		}
 		return list, nil
+	case "modem":
+		data, err := Modem(n.Nodes[0])
+		if err != nil {
+			return nil, &TypeError{Where: r.where(n), Builtin: n.fun.funName, Expected: "a number or a list of numbers"}
+		}
+		return data, nil
+	case "send":
+		if r.Sender == nil {
+			return nil, &SendError{Where: r.where(n), Err: errors.New("no sender configured")}
+		}
+		ctx := r.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		response, err := r.Sender.Send(ctx, n.Nodes[0])
+		if err != nil {
+			return nil, &SendError{Where: r.where(n), Err: err}
+		}
+		return response, nil
+	case "draw":
+		points, ok := vectors(n.Nodes[0])
+		if !ok {
+			return nil, &TypeError{Where: r.where(n), Builtin: n.fun.funName, Expected: "a list of vectors"}
+		}
+		return &Node{nodeType: Pic, picture: NewPicture(points)}, nil
+	case "multipledraw":
+		if n.Nodes[0].nodeType == Fun && n.Nodes[0].funName == "nil" {
+			return n.Nodes[0], nil
+		}
+		if n.Nodes[0].nodeType != Cons || len(n.Nodes[0].Nodes) != 2 {
+			return nil, &TypeError{Where: r.where(n), Builtin: n.fun.funName, Expected: "a list"}
+		}
+		return &Node{nodeType: Cons, Nodes: []*Node{NewAp(NewFun("draw"), n.Nodes[0].Nodes[0]),
+			NewAp(NewFun("multipledraw"), n.Nodes[0].Nodes[1])}}, nil
+	case "pwr2":
+		if n.Nodes[0].nodeType != Num || n.Nodes[0].big != nil || n.Nodes[0].num < 0 ||
+			n.Nodes[0].num > maxPwr2Exponent {
+			return nil, &TypeError{Where: r.where(n), Builtin: n.fun.funName,
+				Expected: fmt.Sprintf("an exponent between 0 and %v", maxPwr2Exponent)}
+		}
+		return NewBigNum(new(big.Int).Lsh(big.NewInt(1), uint(n.Nodes[0].num))), nil
 	case "neg", "inc", "dec", "mod", "dem":
 		if n.Nodes[0].nodeType != Num {
-			return nil, errors.New(fmt.Sprintf("expected single numeric argument: %v", n))
+			return nil, &TypeError{Where: r.where(n), Builtin: n.fun.funName, Expected: "a number"}
 		} else {
 			switch n.fun.funName {
 			case "neg":
-				return &Node{nodeType: Num, num: -n.Nodes[0].num}, nil
+				return negNum(n.Nodes[0]), nil
 			case "inc":
-				return &Node{nodeType: Num, num: n.Nodes[0].num + 1}, nil
+				return addNum(n.Nodes[0], NewNum(1)), nil
 			case "dec":
-				return &Node{nodeType: Num, num: n.Nodes[0].num - 1}, nil
+				return addNum(n.Nodes[0], NewNum(-1)), nil
 			case "mod":
-				return &Node{nodeType: Num, num: n.Nodes[0].num, modulated: modulate(n.Nodes[0].num)}, nil
+				return &Node{nodeType: Num, num: n.Nodes[0].num, big: n.Nodes[0].big,
+					modulated: modulate(n.Nodes[0])}, nil
 			case "dem":
 				num, _ := demodulate([]byte(n.Nodes[0].modulated))
-				return &Node{nodeType: Num, num: num}, nil
+				return num, nil
 			}
 		}
 	case "isnil":
@@ -534,133 +1019,100 @@ func (r *Reducer) ReduceFunction(n *Node) (*Node, error) {
 		}
 	case "car":
 		if n.Nodes[0].nodeType != Cons {
-			return nil, errors.New(fmt.Sprintf("'car' expects CONS: %v", n))
+			return nil, &TypeError{Where: r.where(n), Builtin: "car", Expected: "a cons"}
 		} else {
 			return n.Nodes[0].Nodes[0], nil
 		}
 	case "cdr":
 		if n.Nodes[0].nodeType != Cons {
-			return nil, errors.New(fmt.Sprintf("'cdr' expects CONS: %v", n))
+			return nil, &TypeError{Where: r.where(n), Builtin: "cdr", Expected: "a cons"}
 		} else {
 			return n.Nodes[0].Nodes[1], nil
 		}
-	case "cons", "mul", "div", "add", "eq", "lt", "t", "f":
-		{
-			var node *Node
-			if n.fun.funName == "cons" {
-				node = &Node{nodeType: Cons}
-			} else {
-				node = &Node{nodeType: Closure, funName: n.fun.funName}
-			}
-			node.Nodes = append(node.Nodes, n.Nodes[0])
-			var varName string
-			if n.fun.funName == "t" {
-				// Second argument is ignored.
-				varName = "_"
-				node.Nodes = append(node.Nodes, &Node{nodeType: Ref, funName: "_"})
-			} else {
-				varName = r.newVarName()
-				node.Nodes = append(node.Nodes, &Node{nodeType: Ref, funName: varName})
-			}
-			return &Node{nodeType: Lambda, fun: node, bound: varName}, nil
+	case "cons", "vec", "mul", "div", "add", "eq", "lt", "t", "f", "statelessdraw", "f38",
+		"checkerboard":
+		if n.fun.funName == "t" {
+			// Second argument is ignored.
+			return NewLambda("_", newClosure("t", n.Nodes[0], NewFun("_"))), nil
 		}
-
-	case "double":
-		{
-			varName := r.newVarName()
-			node := &Node{nodeType: Ap, fun: n.Nodes[0], Nodes: []*Node{{nodeType: Ap, fun: n.Nodes[0]}}}
-			node.Nodes[0].Nodes = append(node.Nodes[0].Nodes, &Node{nodeType: Ref, funName: varName})
-			return &Node{nodeType: Lambda, fun: node, bound: varName}, nil
+		if n.fun.funName == "cons" || n.fun.funName == "vec" {
+			return NewLambda("", NewCons(n.Nodes[0], NewVar(0))), nil
 		}
-	case "s", "c", "b", "if0":
-		{
-			closure := &Node{nodeType: Closure, funName: n.fun.funName}
-			closure.Nodes = append(closure.Nodes, n.Nodes[0])
-			firstArg := r.newVarName()
-			secondArg := r.newVarName()
-			if n.fun.funName == "if0" {
-				if n.Nodes[0].nodeType != Num {
-					return nil, errors.New(fmt.Sprintf("'if0' expects numeric first argument: %v", n))
-				}
-				if n.Nodes[0].num == 0 {
-					secondArg = "_"
-				} else {
-					firstArg = "_"
-				}
+		return NewLambda("", newClosure(n.fun.funName, n.Nodes[0], NewVar(0))), nil
+	case "double":
+		return NewLambda("", NewAp(n.Nodes[0], NewAp(n.Nodes[0], NewVar(0)))), nil
+	case "s", "c", "b", "if0", "interact":
+		// Variable 1 is the first argument, as its lambda is further out.
+		first, second := NewVar(1), NewVar(0)
+		firstName, secondName := "", ""
+		if n.fun.funName == "if0" {
+			if n.Nodes[0].nodeType != Num {
+				return nil, &TypeError{Where: r.where(n), Builtin: "if0", Expected: "a number"}
+			}
+			if n.Nodes[0].isZero() {
+				second, secondName = NewFun("_"), "_"
+			} else {
+				first, firstName = NewFun("_"), "_"
 			}
-			closure.Nodes = append(closure.Nodes, &Node{nodeType: Ref, funName: firstArg})
-			closure.Nodes = append(closure.Nodes, &Node{nodeType: Ref, funName: secondArg})
-			return &Node{nodeType: Lambda, fun: &Node{nodeType: Lambda, fun: closure, bound: secondArg},
-				bound: firstArg}, nil
 		}
+		return NewLambda(firstName, NewLambda(secondName, newClosure(n.fun.funName, n.Nodes[0], first, second))), nil
 	case "i":
 		return n.Nodes[0], nil
 	default:
-		return nil, errors.New(fmt.Sprintf("unimplemented function: %v", n))
+		return nil, &UnknownFunctionError{Where: r.where(n), Builtin: n.fun.funName}
 	}
-	return nil, errors.New(fmt.Sprintf("unreachable: %v", n))
+	return nil, &MalformedError{Where: r.where(n), Reason: "unreachable"}
 }
 
 func isTerminal(nt NodeType) bool {
 	switch nt {
-	case Num, Lambda, Fun:
+	case Num, Lambda, Fun, Pic:
 		return true
 	default:
 		return false
 	}
 }
 
-func (r *Reducer) EagerReduce(root **Node) (*Node, error) {
-	for !isTerminal((*root).nodeType) {
-		node, err := r.Reduce(*root)
-		if err != nil {
-			return nil, err
+// isValue reports whether a node of type nt is in weak head normal form.
+func isValue(nt NodeType) bool {
+	return isTerminal(nt) || nt == Cons
+}
+
+// eagerWalk goes through a list and its items breadth first, stopping at terms
+// that still need reducing, so it can be resumed once they are.
+type eagerWalk struct {
+	queue   []**Node
+	visited map[*Node]bool // Shared definitions make lists DAGs.
+}
+
+func newEagerWalk(root **Node) *eagerWalk {
+	return &eagerWalk{queue: []**Node{root}, visited: make(map[*Node]bool)}
+}
+
+// next returns the next term that needs reducing, or nil when there is none.
+func (w *eagerWalk) next() *Node {
+	for len(w.queue) > 0 {
+		p := w.queue[0]
+		node := (*p).value()
+		if node != nil && !isValue(node.nodeType) {
+			return *p
 		}
-		if *root != node {
-			*root = node
-			r.RecordStep()
-		} else {
-			break
+		*p = node
+		w.queue = w.queue[1:]
+		if node != nil && node.nodeType == Cons && !w.visited[node] {
+			w.visited[node] = true
+			w.queue = append(w.queue, &node.Nodes[0], &node.Nodes[1])
 		}
 	}
-	// Make lists strict.
-	maxSteps := 200000
-	steps := 0
-	//r.PrintSteps = true
-	if (*root).nodeType == Cons {
-		queue := []**Node{&(*root).Nodes[0], &(*root).Nodes[1]}
-		//log.Printf("\n====Adding to queue (head): %v\n", r.Root.Nodes[0])
-		//log.Printf("\n====Adding to queue (tail): %v\n", r.Root.Nodes[1])
-		for len(queue) > 0 {
-			steps += 1
-			if steps > maxSteps {
-				break
-			}
-			node := queue[0]
-			queue = queue[1:]
-			//log.Printf("============ Reducing to terminal state: %v", *node)
-			if len(queue) == 7 && (*node).funName == "b" {
-				//r.PrintSteps = true
-			}
-			for !isTerminal((*node).nodeType) {
-				reduced, err := r.Reduce(*node)
-				if err != nil {
-					return nil, err
-				}
-				if *node != reduced {
-					*node = reduced
-					r.RecordStep()
-				} else {
-					break
-				}
-			}
-			if (*node).nodeType == Cons {
-				queue = append(queue, &(*node).Nodes[0])
-				queue = append(queue, &(*node).Nodes[1])
-				//log.Printf("\n====Adding to queue (head): %v\n", (*node).Nodes[0])
-				//log.Printf("\n====Adding to queue (tail): %v\n", (*node).Nodes[1])
-				//log.Printf("\n====Queue length: %v\n", len(queue))
-			}
+	return nil
+}
+
+// EagerReduce reduces *root and, if it is a list, its items as well.
+func (r *Reducer) EagerReduce(root **Node) (*Node, error) {
+	walk := newEagerWalk(root)
+	for node := walk.next(); node != nil; node = walk.next() {
+		if _, err := r.Reduce(node); err != nil {
+			return nil, err
 		}
 	}
 	return *root, nil
@@ -670,159 +1122,440 @@ func (r *Reducer) ReduceRoot() (*Node, error) {
 	return r.EagerReduce(&r.Root)
 }
 
+// cancelCheckInterval is the number of steps between checks of the context.
+const cancelCheckInterval = 1024
+
+// CanceledError is returned when a reduction is stopped through its context.
+type CanceledError struct {
+	Err       error // Either context.Canceled or context.DeadlineExceeded.
+	StepCount int
+	Root      *Node // The root as far as it got reduced.
+}
+
+func (e *CanceledError) Error() string {
+	return fmt.Sprintf("reduction stopped after %v steps: %v", e.StepCount, e.Err)
+}
+
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+// withContext makes the reducer check ctx until the returned function is called.
+func (r *Reducer) withContext(ctx context.Context) func() {
+	prev := r.ctx
+	r.ctx = ctx
+	return func() {
+		r.ctx = prev
+	}
+}
+
+// checkContext returns a CanceledError once the reducer's context is done.
+func (r *Reducer) checkContext() error {
+	if r.ctx == nil {
+		return nil
+	}
+	if err := r.ctx.Err(); err != nil {
+		return &CanceledError{Err: err, StepCount: r.stepCount, Root: r.Root}
+	}
+	return nil
+}
+
+// ReduceRootContext is like ReduceRoot, but stops with a CanceledError when ctx
+// is canceled or its deadline passes.
+func (r *Reducer) ReduceRootContext(ctx context.Context) (*Node, error) {
+	return r.EagerReduceContext(ctx, &r.Root)
+}
+
+// EagerReduceContext is like EagerReduce, but stops with a CanceledError when
+// ctx is canceled or its deadline passes.
+func (r *Reducer) EagerReduceContext(ctx context.Context, root **Node) (*Node, error) {
+	defer r.withContext(ctx)()
+	if err := r.checkContext(); err != nil {
+		return nil, err
+	}
+	return r.EagerReduce(root)
+}
+
+// ReduceContext is like Reduce, but stops with a CanceledError when ctx is
+// canceled or its deadline passes.
+func (r *Reducer) ReduceContext(ctx context.Context, n *Node) (*Node, error) {
+	defer r.withContext(ctx)()
+	if err := r.checkContext(); err != nil {
+		return nil, err
+	}
+	return r.Reduce(n)
+}
+
+// errDemand is returned by a step that needs a subterm in weak head normal form
+// to go on. Reduce then reduces r.demanded and takes the step again, so steps
+// must only demand subterms before making changes that can't be repeated.
+var errDemand = errors.New("subterm needs reducing")
+
+// whnf returns the value of *p and stores it there if *p is reduced already.
+// Otherwise it demands *p.
+func (r *Reducer) whnf(p **Node) (*Node, error) {
+	node := (*p).value()
+	if node != nil && !isValue(node.nodeType) {
+		r.demanded = *p
+		return nil, errDemand
+	}
+	*p = node
+	return node, nil
+}
+
+// force is EagerReduce for steps: it demands the terms of the list at *p one
+// after the other, keeping its progress for when the step is taken again.
+func (r *Reducer) force(p **Node) error {
+	walk, ok := r.walks[p]
+	if !ok {
+		walk = newEagerWalk(p)
+		if r.walks == nil {
+			r.walks = make(map[**Node]*eagerWalk)
+		}
+		r.walks[p] = walk
+	}
+	if node := walk.next(); node != nil {
+		r.demanded = node
+		return errDemand
+	}
+	delete(r.walks, p)
+	return nil
+}
+
+// redex is a step waiting for a subterm to be reduced.
+type redex struct {
+	node    *Node
+	updates []*Node // Nodes to point at the value of node.
+}
+
+// Reduce reduces n to weak head normal form and returns the resulting value.
+// Every application on the way is overwritten in place with an indirection to
+// its result, so all other references to the same node share the work.
+//
+// Steps waiting for subterms are kept on a stack rather than Go's, so the
+// depth of terms is only limited by memory.
 func (r *Reducer) Reduce(n *Node) (*Node, error) {
-	//log.Printf("Reducing: %v", n)
+	node, err := r.reduce(n)
+	if err != nil {
+		// The steps that were forcing lists are abandoned, and start over with
+		// new walks if they are taken again.
+		r.walks = nil
+	}
+	return node, err
+}
+
+func (r *Reducer) reduce(n *Node) (*Node, error) {
 	if n == nil {
 		return nil, nil
 	}
-	r.stepCount += 1
-	if r.MaxStepCount > 0 && r.stepCount > r.MaxStepCount {
-		return nil, errors.New(fmt.Sprintf("Reached max step count: %v", r.MaxStepCount))
+	var waiting []redex
+	var updates []*Node
+	var retry *Node // A step that has been counted already.
+	// Chains of indirections and references take no steps, so cycles in them
+	// are caught by Brent's algorithm: mark is compared with every node on the
+	// chain, and moved along after a doubling number of hops.
+	var mark *Node
+	hops, limit := 0, 1
+	node := n
+	for {
+		for !isValue(node.nodeType) {
+			switch node.nodeType {
+			case Ind, Ref:
+				if node.nodeType == Ref && node.fun == nil {
+					caf, err := r.resolve(node)
+					if err != nil {
+						return nil, err
+					}
+					node.fun = caf
+				}
+				updates = append(updates, node)
+				node = node.fun
+				if node == mark {
+					return nil, &LoopError{Where: r.where(node)}
+				}
+				hops += 1
+				if hops == limit {
+					mark, hops, limit = node, 0, limit*2
+				}
+				continue
+			}
+			mark, hops, limit = nil, 0, 1
+			if node != retry {
+				r.stepCount += 1
+				if r.MaxStepCount > 0 && r.stepCount > r.MaxStepCount {
+					return nil, &StepLimitError{Where: r.where(node), MaxStepCount: r.MaxStepCount}
+				}
+				if r.stepCount%cancelCheckInterval == 0 {
+					if err := r.checkContext(); err != nil {
+						return nil, err
+					}
+				}
+			}
+			retry = nil
+			next, err := r.step(node)
+			if err == errDemand {
+				waiting = append(waiting, redex{node, updates})
+				node, updates = r.demanded, nil
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if next == nil {
+				return nil, &MalformedError{Where: r.where(node), Reason: "reduction is nil"}
+			}
+			if next.def == "" {
+				next.def = node.def
+			}
+			if !next.pos.IsKnown() {
+				next.pos = node.pos
+			}
+			node.update(next)
+			updates = append(updates, node)
+			r.RecordStep()
+			node = next
+		}
+		for _, update := range updates {
+			update.fun = node
+		}
+		if len(waiting) == 0 {
+			return node, nil
+		}
+		top := waiting[len(waiting)-1]
+		waiting = waiting[:len(waiting)-1]
+		node, updates, retry = top.node, top.updates, top.node
+		mark, hops, limit = nil, 0, 1
+	}
+}
+
+// resolve returns the shared instance of the top-level definition ref refers
+// to. Each definition is instantiated at most once per Reducer, so its value is
+// only ever computed once. Definitions that are just a reference to another one
+// share its instance, and a cycle of them is a LoopError.
+func (r *Reducer) resolve(ref *Node) (*Node, error) {
+	id := ref.funName
+	var aliases []string
+	resolving := make(map[string]bool)
+	caf, ok := r.cafs[id]
+	for !ok {
+		def, found := r.vars[id]
+		if !found {
+			return nil, &UnknownRefError{Where: r.where(ref), Ref: id}
+		}
+		if def.nodeType != Ref || def.fun != nil {
+			r.CafMisses += 1
+			caf = def.Clone()
+			caf.tag(id)
+			break
+		}
+		if resolving[id] {
+			where := r.where(ref)
+			where.Def = id
+			return nil, &LoopError{Where: where}
+		}
+		resolving[id] = true
+		aliases = append(aliases, id)
+		id = def.funName
+		caf, ok = r.cafs[id]
+	}
+	if ok {
+		r.CafHits += 1
+	}
+	if r.cafs == nil {
+		r.cafs = make(map[string]*Node)
 	}
-	if r.stepCount%1000000 == 0 {
-		log.Printf("Step: %v  Node Count: %v", r.stepCount, r.Root.NodeCount())
+	r.cafs[id] = caf
+	for _, alias := range aliases {
+		r.cafs[alias] = caf
 	}
+	return caf, nil
+}
+
+func (r *Reducer) StepCount() int {
+	return r.stepCount
+}
+
+// tag records def as the origin of the nodes of a freshly cloned definition.
+func (n *Node) tag(def string) {
+	stack := []*Node{n}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if node == nil || node.def != "" {
+			continue
+		}
+		node.def = def
+		if node.nodeType != Ref {
+			stack = append(stack, node.fun)
+		}
+		stack = append(stack, node.Nodes...)
+	}
+}
+
+// update overwrites a reduced node with an indirection to its result.
+func (n *Node) update(result *Node) {
+	n.nodeType = Ind
+	n.fun = result
+	n.Nodes = nil
+	n.funName = ""
+	n.bound = ""
+}
+
+// step performs a single reduction of the redex n and returns the term it
+// reduces to. The returned term is not necessarily in weak head normal form.
+func (r *Reducer) step(n *Node) (*Node, error) {
 	switch n.nodeType {
-	case Ref:
-		node, ok := r.vars[n.funName]
-		if !ok {
-			return nil, errors.New(fmt.Sprintf("unknown id: %v", n.funName))
-		}
-		r.clones += 1
-		//log.Printf("Cloned: %v", n.funName)
-		//if r.clones > 20000 {
-		//	log.Fatal("too many clones")
-		//}
-		return node.Clone(), nil
-	case Num, Fun, Lambda:
-		return n, nil
-	case Cons:
-		if head, err := r.Reduce(n.Nodes[0]); err != nil {
-			return nil, err
-		} else {
-			if n.Nodes[0] != head {
-				n.Nodes[0] = head
-				r.RecordStep()
-			}
+	case Ap:
+		if n.fun == nil {
+			return nil, &MalformedError{Where: r.where(n), Reason: "fun is nil"}
+		}
+		if len(n.Nodes) != 1 {
+			return nil, &MalformedError{Where: r.where(n), Reason: "application expects exactly one arg"}
 		}
-		if tail, err := r.Reduce(n.Nodes[1]); err != nil {
+		fun, err := r.whnf(&n.fun)
+		if err != nil {
 			return nil, err
-		} else {
-			if n.Nodes[1] != tail {
-				n.Nodes[1] = tail
-				r.RecordStep()
-			}
 		}
-		return n, nil
-	case Ap:
-		switch {
-		case n.fun == nil:
-			return nil, errors.New(fmt.Sprintf("fun is nil: %v", n))
-		case n.fun.nodeType == Cons:
-			// log.Printf("applying cons")
-			node := &Node{nodeType: Ap, fun: &Node{nodeType: Ap, fun: n.Nodes[0], Nodes: []*Node{n.fun.Nodes[0]}},
-				Nodes: []*Node{n.fun.Nodes[1]}}
-			return node, nil
-		case n.fun.nodeType == Ap || n.fun.nodeType == Ref || n.fun.nodeType == Closure:
-			if fun, err := r.Reduce(n.fun); err != nil {
-				return nil, err
-			} else {
-				if fun == nil {
-					return nil, errors.New(fmt.Sprintf("'fun' reduction is nil: %v", n))
-				}
-				n.fun = fun
-				r.RecordStep()
-				return r.Reduce(n)
-			}
-		case n.fun.nodeType == Lambda:
-			{
-				if n.fun.bound == "" {
-					return nil, errors.New(fmt.Sprintf("no bound variable: %v", n))
-				}
-				if len(n.Nodes) != 1 {
-					return nil, errors.New(fmt.Sprintf("lambda expects exactly one arg: %v", n))
-				}
-				instantiated := n.fun.fun
-				// Only use the argument if it's not discarded.
-				if n.fun.bound != "_" {
-					instantiated = n.fun.fun.Instantiate(n.fun.bound, n.Nodes[0])
-				}
-				n.Nodes[0] = instantiated
-				n.fun = &Node{nodeType: Fun, funName: "i"}
-				r.RecordStep()
-				return r.Reduce(n)
+		if fun == nil {
+			return nil, &MalformedError{Where: r.where(n), Reason: "'fun' reduction is nil"}
+		}
+		if fun.def != "" {
+			// What an application reduces to belongs to the function's definition.
+			n.def = fun.def
+		}
+		switch fun.nodeType {
+		case Cons:
+			return &Node{nodeType: Ap, fun: &Node{nodeType: Ap, fun: n.Nodes[0], Nodes: []*Node{fun.Nodes[0]}},
+				Nodes: []*Node{fun.Nodes[1]}}, nil
+		case Lambda:
+			// The body is copied even if it doesn't use the argument, as the
+			// lambda may be shared and must stay intact.
+			body := fun.fun.Instantiate(n.Nodes[0])
+			if body == fun.fun {
+				clone := *body
+				body = &clone
 			}
+			return body, nil
+		case Fun:
+			return r.reduceFunction(n)
 		default:
-			return r.ReduceFunction(n)
+			return nil, &TypeError{Where: r.where(n), Builtin: "ap", Expected: "a function"}
 		}
 	case Closure:
+		return r.reduceClosure(n)
+	}
+	return nil, &MalformedError{Where: r.where(n), Reason: "unimplemented"}
+}
+
+// reduceList reduces n to a list and returns its first count items, reducing
+// each of them to weak head normal form. It returns nil if n is not a list of
+// at least count items.
+func (r *Reducer) reduceList(n **Node, count int) ([]*Node, error) {
+	var items []*Node
+	for len(items) < count {
+		cell, err := r.whnf(n)
+		if err != nil {
+			return nil, err
+		}
+		if cell == nil || cell.nodeType != Cons || len(cell.Nodes) != 2 {
+			return nil, nil
+		}
+		item, err := r.whnf(&cell.Nodes[0])
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		n = &cell.Nodes[1]
+	}
+	return items, nil
+}
+
+// reduceClosure evaluates a builtin whose arguments have all been supplied.
+func (r *Reducer) reduceClosure(n *Node) (*Node, error) {
+	switch n.funName {
+	case "add", "mul", "div", "eq", "lt":
 		{
+			for pos := range n.Nodes {
+				if _, err := r.whnf(&n.Nodes[pos]); err != nil {
+					return nil, err
+				}
+			}
+			if n.Nodes[0].nodeType != Num || n.Nodes[1].nodeType != Num {
+				return nil, &TypeError{Where: r.where(n), Builtin: n.funName, Expected: "two numbers"}
+			}
 			switch n.funName {
-			case "add", "mul", "div", "eq", "lt":
-				{
-					for pos, node := range n.Nodes {
-						if node.nodeType == Num {
-							continue
-						}
-						for {
-							if arg, err := r.Reduce(node); err != nil {
-								return nil, err
-							} else {
-								if n.Nodes[pos] != arg {
-									node = arg
-									n.Nodes[pos] = arg
-									r.RecordStep()
-								} else {
-									break
-								}
-							}
-						}
-					}
-					if n.Nodes[0].nodeType != Num || n.Nodes[1].nodeType != Num {
-						return nil, errors.New(fmt.Sprintf("expected two numeric arguments: %v", n))
-					}
-					switch n.funName {
-					case "add":
-						return &Node{nodeType: Num, num: n.Nodes[0].num + n.Nodes[1].num}, nil
-					case "mul":
-						return &Node{nodeType: Num, num: n.Nodes[0].num * n.Nodes[1].num}, nil
-					case "div":
-						return &Node{nodeType: Num, num: n.Nodes[0].num / n.Nodes[1].num}, nil
-					case "eq":
-						if n.Nodes[0].num == n.Nodes[1].num {
-							return &Node{nodeType: Fun, funName: "t"}, nil
-						} else {
-							return &Node{nodeType: Fun, funName: "f"}, nil
-						}
-					case "lt":
-						if n.Nodes[0].num < n.Nodes[1].num {
-							return &Node{nodeType: Fun, funName: "t"}, nil
-						} else {
-							return &Node{nodeType: Fun, funName: "f"}, nil
-						}
-					}
+			case "add":
+				return addNum(n.Nodes[0], n.Nodes[1]), nil
+			case "mul":
+				return mulNum(n.Nodes[0], n.Nodes[1]), nil
+			case "div":
+				if n.Nodes[1].isZero() {
+					return nil, &TypeError{Where: r.where(n), Builtin: "div", Expected: "a non-zero divisor"}
+				}
+				return divNum(n.Nodes[0], n.Nodes[1]), nil
+			case "eq":
+				if cmpNum(n.Nodes[0], n.Nodes[1]) == 0 {
+					return &Node{nodeType: Fun, funName: "t"}, nil
+				} else {
+					return &Node{nodeType: Fun, funName: "f"}, nil
 				}
-			case "if0":
-				if n.Nodes[0].num == 0 {
-					return n.Nodes[1], nil
+			case "lt":
+				if cmpNum(n.Nodes[0], n.Nodes[1]) < 0 {
+					return &Node{nodeType: Fun, funName: "t"}, nil
 				} else {
-					return n.Nodes[2], nil
+					return &Node{nodeType: Fun, funName: "f"}, nil
 				}
-			case "t":
-				return n.Nodes[0], nil
-			case "f":
-				return n.Nodes[1], nil
-			case "s":
-				return &Node{nodeType: Ap, fun: &Node{nodeType: Ap, fun: n.Nodes[0], Nodes: []*Node{n.Nodes[2]}},
-					Nodes: []*Node{{nodeType: Ap, fun: n.Nodes[1], Nodes: []*Node{n.Nodes[2]}}}}, nil
-			case "c":
-				return &Node{nodeType: Ap, fun: &Node{nodeType: Ap, fun: n.Nodes[0], Nodes: []*Node{n.Nodes[2]}},
-					Nodes: []*Node{n.Nodes[1]}}, nil
-			case "b":
-				return &Node{nodeType: Ap, fun: n.Nodes[0],
-					Nodes: []*Node{{nodeType: Ap, fun: n.Nodes[1], Nodes: []*Node{n.Nodes[2]}}}}, nil
 			}
 		}
+	case "if0":
+		if n.Nodes[0].isZero() {
+			return n.Nodes[1], nil
+		} else {
+			return n.Nodes[2], nil
+		}
+	case "t":
+		return n.Nodes[0], nil
+	case "f":
+		return n.Nodes[1], nil
+	case "s":
+		return &Node{nodeType: Ap, fun: &Node{nodeType: Ap, fun: n.Nodes[0], Nodes: []*Node{n.Nodes[2]}},
+			Nodes: []*Node{{nodeType: Ap, fun: n.Nodes[1], Nodes: []*Node{n.Nodes[2]}}}}, nil
+	case "c":
+		return &Node{nodeType: Ap, fun: &Node{nodeType: Ap, fun: n.Nodes[0], Nodes: []*Node{n.Nodes[2]}},
+			Nodes: []*Node{n.Nodes[1]}}, nil
+	case "b":
+		return &Node{nodeType: Ap, fun: n.Nodes[0],
+			Nodes: []*Node{{nodeType: Ap, fun: n.Nodes[1], Nodes: []*Node{n.Nodes[2]}}}}, nil
+	case "checkerboard":
+		size, err := r.whnf(&n.Nodes[0])
+		if err != nil {
+			return nil, err
+		}
+		if size == nil || size.nodeType != Num || size.big != nil || size.num < 0 || size.num > maxCheckerboardSize {
+			return nil, &TypeError{Where: r.where(n), Builtin: n.funName,
+				Expected: fmt.Sprintf("a size between 0 and %v", maxCheckerboardSize)}
+		}
+		// The second argument is 0 in all known uses and doesn't change the picture.
+		return &Node{nodeType: Pic, picture: checkerboard(size.num)}, nil
+	case "statelessdraw":
+		// Draws the clicked point and keeps the state as it is.
+		return NewList(NewNum(0), n.Nodes[0], NewList(NewList(n.Nodes[1]))), nil
+	case "interact":
+		return NewAp(NewAp(NewFun("f38"), n.Nodes[0]), NewAp(NewAp(n.Nodes[0], n.Nodes[1]), n.Nodes[2])), nil
+	case "f38":
+		items, err := r.reduceList(&n.Nodes[1], 3)
+		if err != nil {
+			return nil, err
+		}
+		if items == nil || items[0].nodeType != Num {
+			return nil, &TypeError{Where: r.where(n), Builtin: n.funName, Expected: "a list of flag, state and data"}
+		}
+		flag, newState, data := items[0], items[1], items[2]
+		if flag.isZero() {
+			return NewList(NewAp(NewFun("modem"), newState), NewAp(NewFun("multipledraw"), data)), nil
+		}
+		return NewAp(NewAp(NewAp(NewFun("interact"), n.Nodes[0]), NewAp(NewFun("modem"), newState)),
+			NewAp(NewFun("send"), data)), nil
 	}
-	return nil, errors.New(fmt.Sprintf("unimplemented: %v", n))
+	return nil, &MalformedError{Where: r.where(n), Reason: "unimplemented"}
 }
@@ -1,61 +1,65 @@
 package eval
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"runtime/debug"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestInstantiate(t *testing.T) {
+	eight := NewNum(8)
 	tests := []struct {
 		node     *Node
-		ref      string
 		sub      *Node
 		expected string
 	}{
 		// Test 0
-		{&Node{nodeType: Ref, funName: "X0"}, "X0", &Node{nodeType: Num, num: 7}, "7"},
+		{NewVar(0), NewNum(7), "7"},
 		// Test 1
-		{&Node{nodeType: Ref, funName: "X0"}, "X1", &Node{nodeType: Num, num: 7}, "X0"},
+		{NewLambda("", NewVar(1)), NewNum(7), "(X0.7)"},
 		// Test 2
-		{&Node{nodeType: Ap, fun: &Node{nodeType: Fun, funName: "neg"},
-			Nodes: []*Node{{nodeType: Ref, funName: "X0"}}},
-			"X0", &Node{nodeType: Num, num: 7}, "(neg 7)"},
+		{NewAp(NewFun("neg"), NewVar(0)), NewNum(7), "(neg 7)"},
 		// Test 3
-		{&Node{nodeType: Closure, funName: "add",
-			Nodes: []*Node{{nodeType: Num, num: 8}, {nodeType: Ref, funName: "X0"}}},
-			"X0", &Node{nodeType: Num, num: 7}, "add(8, 7)"},
+		{newClosure("add", eight, NewVar(0)), NewNum(7), "add(8, 7)"},
 		// Test 4
-		{&Node{nodeType: Ap, fun: &Node{nodeType: Ref, funName: "X0"},
-			Nodes: []*Node{{nodeType: Num, num: 8}}},
-			"X0", &Node{nodeType: Fun, funName: "inc"}, "(inc 8)"},
+		{NewAp(NewVar(0), eight), NewFun("inc"), "(inc 8)"},
+		// Test 5
+		{newClosure("add", NewVar(0), NewAp(NewFun("inc"), NewVar(0))), NewNum(7), "add(7, (inc 7))"},
+		// Test 6
+		{NewLambda("", newClosure("s", eight, NewVar(1), NewVar(0))), NewNum(7), "(X0.s(8, 7, X0))"},
+		// Test 7
+		{NewLambda("x", NewAp(NewVar(0), NewVar(2))), NewNum(7), "(x.(x #0))"},
+		// Test 8
+		{NewLambda("y", NewLambda("", NewAp(NewVar(1), NewVar(2)))), NewFun("i"), "(y.(X1.(y i)))"},
+		// Test 9
+		{NewAp(NewFun("neg"), eight), NewNum(7), "(neg 8)"},
 	}
 	for testId, test := range tests {
-		//if testId != 7 {
-		//	continue
-		//}
-		clone := test.node.Instantiate(test.ref, test.sub)
-		if testId == 3 || testId == 4 {
-			if test.node == clone {
-				t.Fail()
-				t.Errorf("Test %v:\n%v", testId, test.node)
-				t.Errorf("Test %v: Failed to clone top node.", testId)
-			}
-			if test.node.Nodes[0] != clone.Nodes[0] {
-				t.Fail()
-				t.Errorf("Test %v:\n%v", testId, test.node)
-				t.Errorf("Test %v: Failed to retain unaffected branch.", testId)
-			}
-		}
+		clone := test.node.Instantiate(test.sub)
 		if clone == nil {
-			t.Fail()
-			t.Errorf("Test %v:\n%v", testId, test.node)
-			t.Errorf("Test %v: Failed to instantiate.", testId)
-		} else {
-			if got := fmt.Sprint(clone); got != test.expected {
-				t.Errorf("Test %v:\n%v", testId, test.node)
-				t.Errorf("Test %v: Expected instantiation: %v, got: %v", testId, test.expected, got)
-			}
+			t.Errorf("Test %v: Failed to instantiate: %v", testId, test.node)
+			continue
+		}
+		if got := fmt.Sprint(clone); got != test.expected {
+			t.Errorf("Test %v: Expected instantiation: %v, got: %v", testId, test.expected, got)
+		}
+		// Only nodes with free variables are copied.
+		if (clone == test.node) != (test.node.free == 0) {
+			t.Errorf("Test %v: Expected a copy only of open nodes: %v", testId, test.node)
+		}
+		if test.node.nodeType == Closure && test.node.Nodes[0] == eight && clone.Nodes[0] != eight {
+			t.Errorf("Test %v: Failed to retain unaffected branch.", testId)
+		}
+		if clone.free != 0 && testId != 7 {
+			t.Errorf("Test %v: Expected a closed result, got %v free", testId, clone.free)
 		}
 	}
 }
@@ -176,7 +180,7 @@ func TestEval(t *testing.T) {
 		{":1 = ap ap ap if0 ap dec 1 3 ap dec t", true, "3"},
 		// Test 27
 		{":1141 = ap ap c b ap ap s ap ap b c ap ap b ap b b ap eq 0 ap ap b ap c :1141 ap add -1\n:1 = :1141", true,
-			"(X1.c(b, ((s ((b c) ((b (b b)) (eq 0)))) ((b (c :1141)) (add -1))), X1))"},
+			"(X0.c(b, ((s ((b c) ((b (b b)) (eq 0)))) ((b (c :1141)) (add -1))), X0))"},
 		// Test 28
 		{":1 = ap ap ap cons 2 5 add", true, "7"},
 		// Test 29
@@ -244,6 +248,98 @@ func TestEval(t *testing.T) {
 		{":1 = ap ap cons 1 ap ap cons 2 nil\n:2 = ap demlist ap modlist ap ap cons 1 ap ap cons :1 ap ap cons 4 nil",
 			true,
 			"[ 1 :: [ [ 1 :: [ 2 :: nil ] ] :: [ 4 :: nil ] ] ]"},
+		// Test 59
+		{":1 = ap ap mul 123229502148636 123229502148636", true, "15185510199800684540636660496"},
+		// Test 60
+		{":1 = ap ap add 9223372036854775807 1", true, "9223372036854775808"},
+		// Test 61
+		{":1 = ap neg -9223372036854775808", true, "9223372036854775808"},
+		// Test 62
+		{":1 = ap dec -9223372036854775808", true, "-9223372036854775809"},
+		// Test 63
+		{":1 = ap ap div 340282366920938463463374607431768211456 3", true,
+			"113427455640312821154458202477256070485"},
+		// Test 64
+		{":1 = ap ap div 340282366920938463463374607431768211456 ap neg 340282366920938463463374607431768211456",
+			true, "-1"},
+		// Test 65
+		{":1 = ap ap lt 9223372036854775807 9223372036854775808", true, "t"},
+		// Test 66
+		{":1 = ap ap eq 18446744073709551616 ap ap mul 4294967296 4294967296", true, "t"},
+		// Test 67
+		{":1 = ap mod 18446744073709551616", true,
+			"0111111111111111111000010000000000000000000000000000000000000000000000000000000000000000"},
+		// Test 68
+		{":1 = ap mod -9223372036854775808", true,
+			"10111111111111111101000000000000000000000000000000000000000000000000000000000000000"},
+		// Test 69
+		{":1 = ap dem ap mod -340282366920938463463374607431768211456", true,
+			"-340282366920938463463374607431768211456"},
+		// Test 70
+		{":1 = ap dem ap mod 9223372036854775807", true, "9223372036854775807"},
+		// Test 71
+		{":1 = ap demlist ap modlist ap ap cons 18446744073709551616 ap ap cons -5 nil", true,
+			"[ 18446744073709551616 :: [ -5 :: nil ] ]"},
+		// Test 72
+		{":1 = ap pwr2 0", true, "1"},
+		// Test 73
+		{":1 = ap pwr2 8", true, "256"},
+		// Test 74
+		{":1 = ap pwr2 ap ap mul 8 8", true, "18446744073709551616"},
+		// Test 75
+		{":1 = ap ap vec 1 2", true, "[ 1 :: 2 ]"},
+		// Test 76
+		{":1 = ap ap ap vec 2 5 add", true, "7"},
+		// Test 77
+		{":1 = ap modem -256", true, "-256"},
+		// Test 78
+		{":1 = ap modem ap mod 17", true, "17"},
+		// Test 79
+		{":1 = ap modem ap ap cons 1 ap ap cons ap ap add 1 1 nil", true, "[ 1 :: [ 2 :: nil ] ]"},
+		// Test 80
+		{":1 = ap ap statelessdraw nil ap ap vec 1 0", true,
+			"[ 0 :: [ nil :: [ [ [ [ 1 :: 0 ] :: nil ] :: nil ] :: nil ] ] ]"},
+		// Test 81
+		{":1 = ap car ap ap f38 statelessdraw ap ap statelessdraw nil ap ap vec 1 0", true, "nil"},
+		// Test 82
+		{":1 = ap car ap ap ap interact statelessdraw nil ap ap vec 1 0", true, "nil"},
+		// Test 83
+		{":1 = ap car ap ap ap interact statelessdraw ap ap cons 5 nil ap ap vec 1 0", true, "[ 5 :: nil ]"},
+		// Test 84
+		{":1 = ap draw nil", true, "<empty picture>"},
+		// Test 85
+		{":1 = ap draw ap ap cons ap ap vec 1 1 nil", true, "<picture (1,1)-(1,1)\n#\n>"},
+		// Test 86
+		{":1 = ap draw ap ap cons ap ap vec 1 2 ap ap cons ap ap vec 3 1 nil", true,
+			"<picture (1,1)-(3,2)\n..#\n#..\n>"},
+		// Test 87
+		{":1 = ap ap checkerboard 3 0", true, "<picture (0,0)-(2,2)\n#.#\n.#.\n#.#\n>"},
+		// Test 88
+		{":1 = ap multipledraw nil", true, "nil"},
+		// Test 89
+		{":1 = ap multipledraw ap ap cons ap ap cons ap ap vec 0 0 nil ap ap cons nil nil", true,
+			"[ <picture (0,0)-(0,0)\n#\n> :: [ <empty picture> :: nil ] ]"},
+		// Test 90
+		{":1 = ap ap ap interact statelessdraw nil ap ap vec 1 0", true,
+			"[ nil :: [ [ <picture (1,0)-(1,0)\n#\n> :: nil ] :: nil ] ]"},
+		// Test 91
+		{":1 = ap \\x -> ap ap add x 1 41", true, "42"},
+		// Test 92
+		{":1 = ap \\x -> ap ap add x x 21", true, "42"},
+		// Test 93
+		{":1 = ap ap \\x -> \\x -> x 1 2", true, "2"},
+		// Test 94
+		{":1 = ap ap \\ x -> \\y -> x 1 2", true, "1"},
+		// Test 95
+		{":1 = ap \\inc -> ap inc 5 dec", true, "4"},
+		// Test 96
+		{":2 = \\f -> \\x -> ap f ap f x\n:1 = ap ap :2 inc 5", true, "7"},
+		// Test 97
+		{":2 = \\n -> ap ap ap if0 n 1 ap ap mul n ap :2 ap dec n\n:1 = ap :2 4", true, "24"},
+		// Test 98
+		{":1 = \\x -> ap inc x", true, "(x.(inc x))"},
+		// Test 99
+		{":1 = ap \\x -> \\y -> ap x y inc", true, "(y.(inc y))"},
 	}
 	for testId, test := range tests {
 		//if testId != 32 {
@@ -280,9 +376,811 @@ func TestEval(t *testing.T) {
 				t.Errorf("Test %v: Failed to reduce. expected: %v, got: %v", testId, test.expected, result)
 				failed = true
 			}
+			if !failed && test.correct {
+				failed = !testOptimized(t, testId, &parser, test.expressions, definitionName(&parser, node), result,
+					reducer.MaxStepCount)
+			}
 		}
 		if failed {
 			break
 		}
 	}
 }
+
+// testOptimized checks that the definition name in expressions reduces to the
+// same as expected, which p reduced it to in at most maxSteps, once the
+// definitions are optimized.
+func testOptimized(t *testing.T, testId int, p *Parser, expressions, name string, expected *Node, maxSteps int) bool {
+	var parser Parser
+	if _, err := parser.Parse(expressions); err != nil {
+		t.Errorf("Test %v: Failed to parse: %v", testId, err)
+		return false
+	}
+	parser.Optimize(AllOptimizations)
+	reduce := func(p *Parser, node *Node) (*Node, error) {
+		reducer := p.NewReducer(node, false)
+		reducer.MaxStepCount = maxSteps
+		return reducer.ReduceRoot()
+	}
+	result, err := reduce(&parser, parser.Vars[name])
+	if err != nil {
+		t.Errorf("Test %v: Failed to reduce optimized %v: %v", testId, parser.Vars[name], err)
+		return false
+	}
+	// Functions can print differently once optimized, so they are compared by
+	// what they give for the same arguments. Anything else has to match.
+	for arg := int64(1); fmt.Sprint(result) != fmt.Sprint(expected); arg += 1 {
+		if arg > 3 || !isFunction(result) || !isFunction(expected) {
+			t.Errorf("Test %v: Optimized %v reduced to: %v, expected: %v", testId, parser.Vars[name], result, expected)
+			return false
+		}
+		var expectedErr error
+		result, err = reduce(&parser, NewAp(result, NewNum(arg)))
+		expected, expectedErr = reduce(p, NewAp(expected, NewNum(arg)))
+		if err != nil || expectedErr != nil {
+			t.Errorf("Test %v: Optimized %v applied to %v failed with: %v, expected: %v", testId, parser.Vars[name], arg,
+				err, expectedErr)
+			return false
+		}
+	}
+	return true
+}
+
+// isFunction reports whether the reduced node n can only be told apart from
+// others by applying it.
+func isFunction(n *Node) bool {
+	n = n.value()
+	return n != nil && n.nodeType != Num && n.nodeType != Cons && n.nodeType != Pic && !n.IsNil()
+}
+
+// definitionName returns the name of the definition node in p.
+func definitionName(p *Parser, node *Node) string {
+	for name, def := range p.Vars {
+		if def == node {
+			return name
+		}
+	}
+	return ""
+}
+
+func TestSharing(t *testing.T) {
+	// Each definition doubles the previous one through a shared argument, so
+	// without sharing the number of steps grows exponentially with depth.
+	depth := 20
+	var defs []string
+	defs = append(defs, ":0 = 1")
+	for i := 1; i <= depth; i += 1 {
+		defs = append(defs, fmt.Sprintf(":%v = ap ap ap s add i :%v", i, i-1))
+	}
+	var parser Parser
+	node, err := parser.Parse(strings.Join(defs, "\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	reducer := parser.NewReducer(node, false)
+	reducer.MaxStepCount = 1000
+	result, err := reducer.ReduceRoot()
+	if err != nil {
+		t.Fatalf("Failed to reduce: %v", err)
+	}
+	if got, expected := fmt.Sprint(result), fmt.Sprint(1<<depth); got != expected {
+		t.Errorf("Expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestCafCache(t *testing.T) {
+	var parser Parser
+	node, err := parser.Parse(":1 = ap ap add 2 3\n:2 = ap ap add :1 ap ap mul :1 :1")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	reducer := parser.NewReducer(node, false)
+	reducer.MaxStepCount = 100
+	result, err := reducer.ReduceRoot()
+	if err != nil {
+		t.Fatalf("Failed to reduce: %v", err)
+	}
+	if got := fmt.Sprint(result); got != "30" {
+		t.Errorf("Expected: 30, got: %v", got)
+	}
+	if reducer.CafMisses != 1 || reducer.CafHits != 2 {
+		t.Errorf("Expected 1 miss and 2 hits, got: %v misses and %v hits", reducer.CafMisses, reducer.CafHits)
+	}
+}
+
+func TestConcurrentReducers(t *testing.T) {
+	// Meant to be run with -race.
+	var parser Parser
+	if _, err := parser.Parse(":1 = ap ap cons 1 :2\n:2 = ap ap cons ap ap ap s mul i 7 nil\n" +
+		":3 = ap ap ap b inc dec ap car :1\n:4 = ap ap cons :3 :2"); err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	expected := "[ 1 :: [ 49 :: nil ] ]"
+	options := []PrintOptions{{}, {ShowSharing: true}, {ShowAddr: true}}
+	errs := make(chan error)
+	for i := 0; i < 8; i += 1 {
+		go func(i int) {
+			reducer := parser.NewReducerWithConfig(parser.Vars[":4"],
+				ReducerConfig{MaxStepCount: 1000, KeepSteps: true, Print: options[i%len(options)]})
+			result, err := reducer.ReduceRoot()
+			if err == nil && fmt.Sprint(result) != expected {
+				err = fmt.Errorf("reducer %v: expected: %v, got: %v", i, expected, result)
+			}
+			errs <- err
+		}(i)
+	}
+	for i := 0; i < 8; i += 1 {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if got := fmt.Sprint(parser.Vars[":4"]); got != "((cons :3) :2)" {
+		t.Errorf("Definition changed by reduction: %v", got)
+	}
+}
+
+func TestReduceRootContext(t *testing.T) {
+	var parser Parser
+	// Reduces forever: s i i x = x x.
+	node, err := parser.Parse(":1 = ap ap ap s i i ap ap s i i")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	tests := []struct {
+		timeout  time.Duration
+		expected error
+	}{
+		// Test 0
+		{10 * time.Millisecond, context.DeadlineExceeded},
+		// Test 1
+		{0, context.Canceled},
+	}
+	for testId, test := range tests {
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if test.timeout > 0 {
+			ctx, cancel = context.WithTimeout(context.Background(), test.timeout)
+			defer cancel()
+		} else {
+			// Canceled before reducing.
+			ctx, cancel = context.WithCancel(context.Background())
+			cancel()
+		}
+		reducer := parser.NewReducer(node, false)
+		_, err := reducer.ReduceRootContext(ctx)
+		var canceled *CanceledError
+		if !errors.As(err, &canceled) || !errors.Is(err, test.expected) {
+			t.Errorf("Test %v: Expected CanceledError with %v, got: %v", testId, test.expected, err)
+			continue
+		}
+		if canceled.Root == nil || canceled.StepCount != reducer.StepCount() {
+			t.Errorf("Test %v: Expected partial root and step count %v, got: %v", testId,
+				reducer.StepCount(), canceled)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		expressions string
+		check       func(err error) bool
+	}{
+		// Test 0
+		{":1 = ap ap add nil 3\n:2 = ap inc :1", func(err error) bool {
+			var e *TypeError
+			return errors.As(err, &e) && e.Builtin == "add" && e.Def == ":1" && e.Term == "add(nil, 3)" &&
+				e.Pos == Pos{1, 6}
+		}},
+		// Test 1
+		{":1 = ap car 5", func(err error) bool {
+			var e *TypeError
+			return errors.As(err, &e) && e.Builtin == "car" && e.Def == "" && e.Step > 0
+		}},
+		// Test 2
+		{":1 = ap frobnicate 5", func(err error) bool {
+			var e *UnknownFunctionError
+			return errors.As(err, &e) && e.Builtin == "frobnicate"
+		}},
+		// Test 3
+		{":1 = ap inc :1000\n:2 = :1", func(err error) bool {
+			var e *UnknownRefError
+			return errors.As(err, &e) && e.Ref == ":1000" && e.Def == ":1"
+		}},
+		// Test 4
+		{":1 = ap ap ap s i i ap ap s i i", func(err error) bool {
+			var e *StepLimitError
+			return errors.As(err, &e) && e.MaxStepCount == 100 && e.Step == 101 && len(e.Term) < 200
+		}},
+		// Test 5
+		{":1 = ap pwr2 10000000000", func(err error) bool {
+			var e *TypeError
+			return errors.As(err, &e) && e.Builtin == "pwr2" && e.Expected == "an exponent between 0 and 65536"
+		}},
+		// Test 6
+		{":1 = :1", func(err error) bool {
+			var e *LoopError
+			return errors.As(err, &e) && e.Def == ":1"
+		}},
+		// Test 7
+		{":1 = ap i :1", func(err error) bool {
+			var e *LoopError
+			return errors.As(err, &e) && e.Def == ":1"
+		}},
+		// Test 8
+		{":1 = :2\n:2 = :1", func(err error) bool {
+			var e *LoopError
+			return errors.As(err, &e)
+		}},
+	}
+	for testId, test := range tests {
+		var parser Parser
+		node, err := parser.Parse(test.expressions)
+		if err != nil {
+			t.Errorf("Test %v: Failed to parse: %v", testId, err)
+			continue
+		}
+		reducer := parser.NewReducer(node, false)
+		reducer.MaxStepCount = 100
+		// Loops that escape the step limit end with a CanceledError instead.
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if _, err := reducer.ReduceRootContext(ctx); !test.check(err) {
+			t.Errorf("Test %v: Unexpected error: %#v", testId, err)
+		}
+		cancel()
+	}
+}
+
+func TestForceError(t *testing.T) {
+	var parser Parser
+	node, err := parser.Parse(":1 = ap modlist ap ap cons 1 ap ap cons ap car 5 nil")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	reducer := parser.NewReducer(node, false)
+	for i := 0; i < 2; i += 1 {
+		var e *TypeError
+		if _, err := reducer.ReduceRoot(); !errors.As(err, &e) || e.Builtin != "car" {
+			t.Errorf("Round %v: Expected a TypeError from car, got: %v", i, err)
+		}
+		if len(reducer.walks) != 0 {
+			t.Errorf("Round %v: Expected no walks left after the error, got: %v", i, len(reducer.walks))
+		}
+	}
+}
+
+func TestLoopValues(t *testing.T) {
+	// The definitions are left cyclic by the failed reduction, which must not
+	// keep the value of :1 or :2 from being looked at.
+	var parser Parser
+	node, err := parser.Parse(":1 = ap i :2\n:2 = :1")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	reducer := parser.NewReducer(node, false)
+	var e *LoopError
+	if _, err := reducer.ReduceRoot(); !errors.As(err, &e) {
+		t.Fatalf("Expected a LoopError, got: %v", err)
+	}
+	if reducer.Root.IsNil() || reducer.Root.Type() != Ind && reducer.Root.Type() != Ref {
+		t.Errorf("Expected the loop to have no value, got: %v", reducer.Root.Type())
+	}
+	if _, err := ModulateList(reducer.Root, nil); err == nil {
+		t.Errorf("Expected the loop not to modulate")
+	}
+	if got := fmt.Sprint(reducer.Root); got != ":1" {
+		t.Errorf("Expected: :1, got: %v", got)
+	}
+	if reducer.CafMisses != 1 {
+		t.Errorf("Expected :2 to share the instance of :1, got: %v misses", reducer.CafMisses)
+	}
+}
+
+type fakeSender struct {
+	sent     []string
+	response string
+}
+
+func (s *fakeSender) Send(ctx context.Context, data *Node) (*Node, error) {
+	s.sent = append(s.sent, fmt.Sprint(data))
+	var parser Parser
+	return parser.Parse(":1 = " + s.response)
+}
+
+func TestInteractSend(t *testing.T) {
+	// The protocol returns the vector it's given, so the first round asks to
+	// send 42 and the response ends the interaction with state 7.
+	var parser Parser
+	node, err := parser.Parse(":1 = ap car ap ap ap interact ap t i nil ap ap cons 1 ap ap cons 5 ap ap cons 42 nil")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	sender := &fakeSender{response: "ap ap cons 0 ap ap cons 7 ap ap cons nil nil"}
+	reducer := parser.NewReducerWithConfig(node, ReducerConfig{MaxStepCount: 1000, Sender: sender})
+	result, err := reducer.ReduceRoot()
+	if err != nil {
+		t.Fatalf("Failed to reduce: %v", err)
+	}
+	if got := fmt.Sprint(result); got != "7" {
+		t.Errorf("Expected final state: 7, got: %v", got)
+	}
+	if got := strings.Join(sender.sent, ", "); got != "42" {
+		t.Errorf("Expected to send: 42, got: %v", got)
+	}
+
+	reducer = parser.NewReducer(node, false)
+	var sendErr *SendError
+	if _, err := reducer.ReduceRoot(); !errors.As(err, &sendErr) {
+		t.Errorf("Expected SendError without a Sender, got: %v", err)
+	}
+}
+
+func TestPictures(t *testing.T) {
+	var parser Parser
+	node, err := parser.Parse(":1 = ap multipledraw ap ap cons ap ap cons ap ap vec -1 2 ap ap cons ap ap vec 3 0 " +
+		"ap ap cons ap ap vec 3 0 nil ap ap cons nil nil")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	reducer := parser.NewReducer(node, false)
+	result, err := reducer.ReduceRoot()
+	if err != nil {
+		t.Fatalf("Failed to reduce: %v", err)
+	}
+	pictures, err := Pictures(result)
+	if err != nil {
+		t.Fatalf("Failed to get pictures: %v", err)
+	}
+	if len(pictures) != 2 {
+		t.Fatalf("Expected 2 pictures, got: %v", len(pictures))
+	}
+	first := pictures[0]
+	if got := fmt.Sprint(first.Points); got != "[{-1 2} {3 0}]" {
+		t.Errorf("Expected points without duplicates, got: %v", got)
+	}
+	if first.Min != (Point{-1, 0}) || first.Max != (Point{3, 2}) || first.Width() != 5 || first.Height() != 3 {
+		t.Errorf("Unexpected bounding box: %v - %v", first.Min, first.Max)
+	}
+	if !first.Contains(Point{3, 0}) || first.Contains(Point{0, 0}) {
+		t.Errorf("Contains() disagrees with points: %v", first.Points)
+	}
+	if len(pictures[1].Points) != 0 || pictures[1].Width() != 0 {
+		t.Errorf("Expected empty picture, got: %v", pictures[1])
+	}
+	if _, err := Pictures(result.Nodes[1].Nodes[0]); err == nil {
+		t.Errorf("Expected error for a picture that's not in a list")
+	}
+	far := NewPicture([]Point{{math.MinInt64, 0}, {math.MaxInt64, 1}})
+	if far.Width() != math.MaxInt64 || far.Height() != 2 {
+		t.Errorf("Expected saturated width, got: %vx%v", far.Width(), far.Height())
+	}
+	if expected := "<picture (-9223372036854775808,0)-(9223372036854775807,1) of 2 points>"; far.String() != expected {
+		t.Errorf("Expected: %v, got: %v", expected, far.String())
+	}
+}
+
+func TestPicturesThroughRefs(t *testing.T) {
+	resolved := func(target *Node) *Node {
+		ref := NewRef(":1")
+		ref.fun = target
+		return ref
+	}
+	vector := resolved(&Node{nodeType: Cons, Nodes: []*Node{resolved(NewNum(1)), NewNum(2)}})
+	points, ok := vectors(resolved(&Node{nodeType: Cons, Nodes: []*Node{vector, resolved(NewFun("nil"))}}))
+	if !ok || fmt.Sprint(points) != "[{1 2}]" {
+		t.Errorf("Expected vectors through refs, got: %v", points)
+	}
+	picture := resolved(&Node{nodeType: Pic, picture: NewPicture(points)})
+	if _, ok := picture.Picture(); !ok {
+		t.Errorf("Expected picture through a ref")
+	}
+	pictures, err := Pictures(resolved(&Node{nodeType: Cons, Nodes: []*Node{picture, resolved(NewFun("nil"))}}))
+	if err != nil || len(pictures) != 1 {
+		t.Errorf("Expected one picture through refs, got: %v, %v", pictures, err)
+	}
+}
+
+func TestTokenizer(t *testing.T) {
+	tokenizer := NewTokenizer(strings.NewReader(" :1  =\tap inc\r\n\n  λ 2\n"))
+	var got []string
+	for {
+		token, err := tokenizer.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to tokenize: %v", err)
+		}
+		got = append(got, fmt.Sprintf("%v@%v:%v", token.Text, token.Pos.Line, token.Pos.Col))
+	}
+	if expected := "[:1@1:2 =@1:6 ap@1:8 inc@1:11 λ@3:3 2@3:5]"; fmt.Sprint(got) != expected {
+		t.Errorf("Expected: %v, got: %v", expected, got)
+	}
+	if pos := tokenizer.Pos(); pos != (Pos{4, 1}) {
+		t.Errorf("Expected to end at 4:1, got: %v", pos)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expressions string
+		expected    string
+	}{
+		// Test 0
+		{":1 = ap inc", "line 1, column 12: expected expression after 'inc' at line 1, column 9"},
+		// Test 1
+		{":1 = 1\n:2 2", "line 2, column 4: expected '=' after ':2', got '2'"},
+		// Test 2
+		{":1 = 1 2\n:2 = 3", "line 2, column 1: expected '=' after '2', got ':2'"},
+		// Test 3
+		{":1 = ap = 2", "line 1, column 9: unexpected '='"},
+		// Test 4
+		{":1", "line 1, column 3: expected '=' after ':1' at line 1, column 1"},
+		// Test 5
+		{"\n:1 =\n", "line 3, column 1: expected expression after '=' at line 2, column 4"},
+		// Test 6
+		{":1 = \\ -> 1", "line 1, column 6: invalid variable name '->'"},
+		// Test 7
+		{":1 = \\x 1", "line 1, column 9: expected '->' after '\\x', got '1'"},
+		// Test 8
+		{":1 = \\1 -> 1", "line 1, column 6: invalid variable name '1'"},
+		// Test 9
+		{":1 = ap inc ->", "line 1, column 13: unexpected '->'"},
+		// Test 10
+		{":1 = \\x ->", "line 1, column 11: expected expression after '->' at line 1, column 9"},
+	}
+	for testId, test := range tests {
+		var parser Parser
+		_, err := parser.Parse(test.expressions)
+		var e *ParseError
+		if !errors.As(err, &e) || err.Error() != test.expected {
+			t.Errorf("Test %v: Expected error: %v, got: %v", testId, test.expected, err)
+		}
+	}
+}
+
+func TestParsePositions(t *testing.T) {
+	var parser Parser
+	// Definitions may span lines, as any whitespace separates tokens.
+	node, err := parser.Parse(":1 = ap\n  ap add 1\n  :2\n:2 = 5")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	root := parser.Vars[":1"]
+	got := fmt.Sprint(root.Pos(), root.fun.Pos(), root.fun.fun.Pos(), root.fun.Nodes[0].Pos(), root.Nodes[0].Pos(),
+		node.Pos(), root.Clone().Nodes[0].Pos())
+	expected := "line 1, column 6 line 2, column 3 line 2, column 6 line 2, column 10 line 3, column 3 " +
+		"line 4, column 6 line 3, column 3"
+	if got != expected {
+		t.Errorf("Expected: %v, got: %v", expected, got)
+	}
+	if NewNum(1).Pos().IsKnown() {
+		t.Errorf("Expected no position for a node made by NewNum")
+	}
+}
+
+// deepTests runs the slower of the million-deep cases as well. Set DEEP_TESTS
+// in the environment to enable them.
+var deepTests = os.Getenv("DEEP_TESTS") != ""
+
+func TestDeepTerms(t *testing.T) {
+	if testing.Short() || raceEnabled {
+		t.Skip("Skipping million-deep terms in short mode or with the race detector")
+	}
+	// Far deeper than the stack below allows if parsing or reduction recursed.
+	const depth = 1000000
+	repeat := func(s string, n int) string {
+		return strings.Repeat(s, n)
+	}
+	// The list is built directly, as TestDeepParse parses one already.
+	items := make([]*Node, depth)
+	for i := range items {
+		items[i] = NewNum(int64(i))
+	}
+	tests := []struct {
+		expression string
+		list       *Node // Defined as :list, if set.
+		expected   string
+		slow       bool // Only run with DEEP_TESTS.
+	}{
+		// Test 0
+		{":1 = " + repeat("ap inc ", depth) + "0", nil, fmt.Sprint(depth), false},
+		// Test 1
+		{":1 = " + repeat("ap ", depth) + "i i" + repeat(" i", depth-1), nil, "i", true},
+		// Test 2
+		{":1 = ap car " + repeat("ap cdr ", depth-1) + ":list", NewList(items...), fmt.Sprint(depth - 1), false},
+	}
+	// Reduction mustn't recurse on Go's stack, which would soon run out.
+	defer debug.SetMaxStack(debug.SetMaxStack(1 << 20))
+	for testId, test := range tests {
+		if test.slow && !deepTests {
+			continue
+		}
+		var parser Parser
+		node, err := parser.Parse(test.expression)
+		if err != nil {
+			t.Fatalf("Test %v: Failed to parse: %v", testId, err)
+		}
+		if test.list != nil {
+			parser.Vars[":list"] = test.list
+		}
+		result, err := parser.NewReducer(node, false).ReduceRoot()
+		if err != nil {
+			t.Fatalf("Test %v: Failed to reduce: %v", testId, err)
+		}
+		if got := fmt.Sprint(result); got != test.expected {
+			t.Errorf("Test %v: Expected: %v, got: %v", testId, test.expected, got)
+		}
+	}
+}
+
+func TestDeepParse(t *testing.T) {
+	if testing.Short() || raceEnabled {
+		t.Skip("Skipping a million-deep list in short mode or with the race detector")
+	}
+	const depth = 1000000
+	defer debug.SetMaxStack(debug.SetMaxStack(1 << 20))
+	var parser Parser
+	node, err := parser.Parse(":1 = " + strings.Repeat("ap ap cons 7 ", depth) + "nil")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	length := 0
+	for ; node.nodeType == Ap; node = node.Nodes[0] {
+		if head := node.fun; head.nodeType != Ap || head.fun.funName != "cons" || head.Nodes[0].num != 7 {
+			t.Fatalf("Expected ap ap cons 7 at item %v, got: %v", length, errorPrint.Sprint(node))
+		}
+		length += 1
+	}
+	if length != depth || !node.IsNil() {
+		t.Errorf("Expected %v items ending in nil, got: %v ending in %v", depth, length, node)
+	}
+}
+
+func TestDeepLists(t *testing.T) {
+	// Long enough to need more than 1MB of stack if modulation recursed, with
+	// items that eager reduction has to reach all the way to the end.
+	length := 50000
+	if deepTests && !testing.Short() {
+		length = 1000000
+	}
+	items := make([]*Node, length)
+	for i := range items {
+		items[i] = NewAp(NewFun("inc"), NewNum(int64(i-1)))
+	}
+	defer debug.SetMaxStack(debug.SetMaxStack(1 << 20))
+	var parser Parser
+	list, err := parser.NewReducer(NewList(items...), false).ReduceRoot()
+	if err != nil {
+		t.Fatalf("Failed to reduce: %v", err)
+	}
+	bytes, err := ModulateList(list, nil)
+	if err != nil {
+		t.Fatalf("Failed to modulate: %v", err)
+	}
+	reducer := parser.NewReducer(NewAp(NewFun("demlist"), &Node{nodeType: Num, modulated: string(bytes)}), false)
+	result, err := reducer.ReduceRoot()
+	if err != nil {
+		t.Fatalf("Failed to demodulate: %v", err)
+	}
+	got, ok := result.Items()
+	if !ok || len(got) != length || fmt.Sprint(got[length-1]) != fmt.Sprint(length-1) {
+		t.Errorf("Expected %v items, got: %v", length, len(got))
+	}
+	modem, err := parser.NewReducer(NewAp(NewFun("modem"), list), false).ReduceRoot()
+	if err != nil {
+		t.Fatalf("Failed to modem: %v", err)
+	}
+	if got, ok := modem.Items(); !ok || len(got) != length {
+		t.Errorf("Expected %v items after modem, got: %v", length, len(got))
+	}
+}
+
+func TestWriteNode(t *testing.T) {
+	huge, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+	tests := []struct {
+		node     *Node
+		expected string
+	}{
+		// Test 0
+		{NewAp(NewAp(NewFun("add"), NewNum(-1)), NewRef(":2")), "ap ap add -1 :2"},
+		// Test 1
+		{NewList(NewNum(1), NewList(NewBigNum(huge))), "ap ap cons 1 ap ap cons ap ap cons " + huge.String() + " nil nil"},
+		// Test 2
+		{&Node{nodeType: Ind, fun: NewFun("nil")}, "nil"},
+		// Test 3
+		{&Node{nodeType: Num, num: 5, modulated: modulate(NewNum(5))}, "ap mod 5"},
+		// Test 4
+		{&Node{nodeType: Num, modulated: "1101100001110110001000"}, "ap modlist ap ap cons 1 ap ap cons 2 nil"},
+		// Test 5
+		{NewAp(NewFun("inc"), nil), "error: can't write <nil>"},
+		// Test 6
+		{NewLambda("x", NewAp(NewFun("inc"), NewVar(0))), "\\x -> ap inc x"},
+		// Test 7
+		{NewLambda("x", NewLambda("x", NewAp(NewVar(1), NewVar(0)))), "\\x -> \\x1 -> ap x x1"},
+		// Test 8
+		{NewLambda("", NewLambda("inc", NewAp(NewFun("inc"), NewVar(1)))), "\\x -> \\inc1 -> ap inc x"},
+		// Test 9
+		{NewLambda("x", NewVar(1)), "error: can't write free variable #1"},
+		// Test 10
+		{&Node{nodeType: Closure, funName: "add"}, "error: can't write add()"},
+	}
+	for testId, test := range tests {
+		var b strings.Builder
+		got := ""
+		if err := WriteNode(&b, test.node); err != nil {
+			got = "error: " + err.Error()
+		} else {
+			got = b.String()
+		}
+		if got != test.expected {
+			t.Errorf("Test %v: Expected: %v, got: %v", testId, test.expected, got)
+		}
+	}
+}
+
+// sameTree reports whether a and b are the same tree, apart from positions.
+func sameTree(a, b *Node) bool {
+	stack := [][2]*Node{{a, b}}
+	for len(stack) > 0 {
+		a, b := stack[len(stack)-1][0], stack[len(stack)-1][1]
+		stack = stack[:len(stack)-1]
+		if a == nil || b == nil {
+			if a != b {
+				return false
+			}
+			continue
+		}
+		if a.nodeType != b.nodeType || a.funName != b.funName || a.num != b.num || len(a.Nodes) != len(b.Nodes) ||
+			(a.big == nil) != (b.big == nil) || a.big != nil && a.big.Cmp(b.big) != 0 {
+			return false
+		}
+		stack = append(stack, [2]*Node{a.fun, b.fun})
+		for pos := range a.Nodes {
+			stack = append(stack, [2]*Node{a.Nodes[pos], b.Nodes[pos]})
+		}
+	}
+	return true
+}
+
+func TestWriteDefinitions(t *testing.T) {
+	file, err := os.Open("../galaxy.txt")
+	if err != nil {
+		t.Skipf("galaxy.txt not available: %v", err)
+	}
+	defer file.Close()
+	var parser Parser
+	if _, err := parser.ParseReader(file); err != nil {
+		t.Fatalf("Failed to parse galaxy.txt: %v", err)
+	}
+	var written strings.Builder
+	if err := WriteDefinitions(&written, parser.Vars); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	var reparser Parser
+	last, err := reparser.Parse(written.String())
+	if err != nil {
+		t.Fatalf("Failed to parse what was written: %v", err)
+	}
+	if len(reparser.Vars) != len(parser.Vars) {
+		t.Errorf("Expected %v definitions, got: %v", len(parser.Vars), len(reparser.Vars))
+	}
+	for name, node := range parser.Vars {
+		if !sameTree(node, reparser.Vars[name]) {
+			t.Errorf("Definition %v changed: %v", name, reparser.Vars[name])
+		}
+	}
+	// The numbered definitions come first, so galaxy.txt's last one stays last.
+	if !sameTree(last, parser.Vars["interact1"]) {
+		t.Errorf("Expected interact1 last, got: %v", last)
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		config      string
+		definitions string
+		expected    string
+		nodesBefore int
+		nodesAfter  int
+	}{
+		// Test 0
+		{"fold", ":1 = ap ap add 2 ap ap mul 3 ap neg 4\n:2 = ap ap add 1 :1", ":1 = -10\n:2 = ap ap add 1 :1\n", 16, 6},
+		// Test 1
+		{"i", ":1 = ap ap i add ap i 1", ":1 = ap add 1\n", 7, 3},
+		// Test 2
+		{"combinators", ":1 = ap ap b inc i\n:2 = ap c ap c add", ":1 = inc\n:2 = add\n", 10, 2},
+		// Test 3
+		{"inline", ":1 = cons\n:2 = :1\n:3 = ap ap :2 1 :4\n:4 = ap inc :4",
+			":1 = cons\n:2 = cons\n:3 = ap ap cons 1 :4\n:4 = ap inc :4\n", 10, 10},
+		// Test 4
+		{"inline", ":1 = ap pwr2 10\n:2 = ap ap add :1 :1\n:3 = ap add 1\n:4 = :5\n:5 = :4\n:6 = ap :3 :4",
+			":1 = ap pwr2 10\n:2 = ap ap add :1 :1\n:3 = ap add 1\n:4 = :5\n:5 = :4\n:6 = ap ap add 1 :4\n", 16, 18},
+		// Test 5
+		{"eta", ":1 = \\x -> ap inc x\n:2 = \\x -> \\y -> ap ap add x y\n:3 = ap ap s ap t dec i",
+			":1 = inc\n:2 = add\n:3 = dec\n", 18, 3},
+		// Test 6
+		{"fold,i", ":1 = ap ap s ap t ap i dec i", ":1 = ap ap s ap t dec i\n", 9, 7},
+		// Test 7
+		{"all", ":1 = ap ap add 1 2\n:2 = \\x -> ap ap mul :1 x\n:3 = ap ap b ap :2 ap neg 5 i",
+			":1 = 3\n:2 = ap mul 3\n:3 = -15\n", 20, 5},
+	}
+	for testId, test := range tests {
+		config, err := ParseOptimizeConfig(test.config)
+		if err != nil {
+			t.Errorf("Test %v: Failed to parse config: %v", testId, err)
+			continue
+		}
+		var parser Parser
+		if _, err := parser.Parse(test.definitions); err != nil {
+			t.Errorf("Test %v: Failed to parse: %v", testId, err)
+			continue
+		}
+		stats := parser.Optimize(config)
+		var b strings.Builder
+		if err := WriteDefinitions(&b, parser.Vars); err != nil {
+			t.Errorf("Test %v: Failed to write: %v", testId, err)
+		}
+		if b.String() != test.expected {
+			t.Errorf("Test %v: Expected:\n%v\ngot:\n%v", testId, test.expected, b.String())
+		}
+		if stats.NodesBefore != test.nodesBefore || stats.NodesAfter != test.nodesAfter {
+			t.Errorf("Test %v: Expected %v nodes before and %v after, got: %+v", testId, test.nodesBefore,
+				test.nodesAfter, stats)
+		}
+	}
+	if _, err := ParseOptimizeConfig("fold,unroll"); err == nil || err.Error() != "unknown optimization: 'unroll'" {
+		t.Errorf("Expected an unknown optimization, got: %v", err)
+	}
+}
+
+func TestOptimizeErrors(t *testing.T) {
+	// Each definition fails on 'car 5', which the pass mustn't rewrite away.
+	tests := []struct {
+		config     string
+		definition string
+		optimized  string
+	}{
+		// Test 0
+		{"i", ":1 = ap i ap car ap i 5", ":1 = ap car 5\n"},
+		// Test 1
+		{"combinators", ":1 = ap ap ap b car i 5", ":1 = ap car 5\n"},
+		// Test 2
+		{"combinators", ":1 = ap ap ap c ap c car 5 nil", ":1 = ap ap car 5 nil\n"},
+		// Test 3
+		{"eta", ":1 = ap ap ap s ap t car i 5", ":1 = ap car 5\n"},
+		// Test 4
+		{"eta", ":1 = ap \\x -> ap car x 5", ":1 = ap car 5\n"},
+	}
+	for testId, test := range tests {
+		config, err := ParseOptimizeConfig(test.config)
+		if err != nil {
+			t.Errorf("Test %v: Failed to parse config: %v", testId, err)
+			continue
+		}
+		var errs []error
+		for _, optimize := range []bool{false, true} {
+			var parser Parser
+			if _, err := parser.Parse(test.definition); err != nil {
+				t.Fatalf("Test %v: Failed to parse: %v", testId, err)
+			}
+			if optimize {
+				parser.Optimize(config)
+				var b strings.Builder
+				if err := WriteDefinitions(&b, parser.Vars); err != nil || b.String() != test.optimized {
+					t.Errorf("Test %v: Expected:\n%v\ngot:\n%v (%v)", testId, test.optimized, b.String(), err)
+				}
+			}
+			reducer := parser.NewReducer(parser.Vars[":1"], false)
+			reducer.MaxStepCount = 100
+			_, err := reducer.ReduceRoot()
+			errs = append(errs, err)
+		}
+		for _, err := range errs {
+			var e *TypeError
+			if !errors.As(err, &e) || e.Builtin != "car" {
+				t.Errorf("Test %v: Expected a TypeError from car, got: %v", testId, errs)
+				break
+			}
+		}
+	}
+}
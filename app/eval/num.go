@@ -0,0 +1,87 @@
+package eval
+
+import (
+	"math"
+	"math/big"
+)
+
+// Num nodes hold their value in num. Values that don't fit into an int64 are
+// kept in big instead, in which case num is unused.
+
+func NewNum(v int64) *Node {
+	return &Node{nodeType: Num, num: v}
+}
+
+// NewBigNum returns a Num node for v, falling back to the int64 representation
+// whenever v fits into it.
+func NewBigNum(v *big.Int) *Node {
+	if v.IsInt64() {
+		return NewNum(v.Int64())
+	}
+	return &Node{nodeType: Num, big: v}
+}
+
+// bigNum returns the value of a Num node as a big.Int.
+func (n *Node) bigNum() *big.Int {
+	if n.big != nil {
+		return n.big
+	}
+	return big.NewInt(n.num)
+}
+
+func (n *Node) isZero() bool {
+	return n.big == nil && n.num == 0
+}
+
+// cmpNum compares two Num nodes and returns -1, 0 or +1.
+func cmpNum(x, y *Node) int {
+	if x.big == nil && y.big == nil {
+		switch {
+		case x.num < y.num:
+			return -1
+		case x.num > y.num:
+			return 1
+		default:
+			return 0
+		}
+	}
+	return x.bigNum().Cmp(y.bigNum())
+}
+
+func addNum(x, y *Node) *Node {
+	if x.big == nil && y.big == nil {
+		if sum := x.num + y.num; (sum > x.num) == (y.num > 0) {
+			return NewNum(sum)
+		}
+	}
+	return NewBigNum(new(big.Int).Add(x.bigNum(), y.bigNum()))
+}
+
+func mulNum(x, y *Node) *Node {
+	if x.big == nil && y.big == nil {
+		if x.num == 0 || y.num == 0 {
+			return NewNum(0)
+		}
+		product := x.num * y.num
+		if product/y.num == x.num && !(x.num == -1 && y.num == math.MinInt64) &&
+			!(y.num == -1 && x.num == math.MinInt64) {
+			return NewNum(product)
+		}
+	}
+	return NewBigNum(new(big.Int).Mul(x.bigNum(), y.bigNum()))
+}
+
+// divNum divides x by y, truncating towards zero. y must not be zero.
+func divNum(x, y *Node) *Node {
+	if x.big == nil && y.big == nil && !(x.num == math.MinInt64 && y.num == -1) {
+		return NewNum(x.num / y.num)
+	}
+	return NewBigNum(new(big.Int).Quo(x.bigNum(), y.bigNum()))
+}
+
+func negNum(x *Node) *Node {
+	if x.big == nil && x.num != math.MinInt64 {
+		return NewNum(-x.num)
+	}
+	return NewBigNum(new(big.Int).Neg(x.bigNum()))
+}
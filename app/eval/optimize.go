@@ -0,0 +1,312 @@
+package eval
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxInlineNodes is the size of the largest definitions that are inlined.
+const maxInlineNodes = 4
+
+// arities are the numbers of arguments of the builtins that partial
+// applications of are inlined.
+var arities = map[string]int{
+	"i": 1, "neg": 1, "inc": 1, "dec": 1, "car": 1, "cdr": 1, "isnil": 1, "nil": 1,
+	"t": 2, "f": 2, "add": 2, "mul": 2, "div": 2, "eq": 2, "lt": 2,
+	"s": 3, "b": 3, "c": 3, "cons": 3, "vec": 3, "if0": 3,
+}
+
+// OptimizeConfig selects the passes of Parser.Optimize.
+type OptimizeConfig struct {
+	FoldConstants bool // 'ap ap add 2 3' -> 5, likewise for mul and neg.
+	EliminateI    bool // 'ap i x' -> x.
+	Combinators   bool // 'ap ap b f i' -> f and 'ap c ap c f' -> f.
+	Inline        bool // References to tiny definitions that are values -> a copy of them.
+	EtaReduce     bool // '\x -> ap f x' -> f and 'ap ap s ap t f i' -> f.
+}
+
+// AllOptimizations turns on every pass.
+var AllOptimizations = OptimizeConfig{FoldConstants: true, EliminateI: true, Combinators: true, Inline: true,
+	EtaReduce: true}
+
+// ParseOptimizeConfig returns the config with the passes in a comma separated
+// list such as "fold,eta". The passes are fold, i, combinators, inline and
+// eta, or all of them.
+func ParseOptimizeConfig(passes string) (OptimizeConfig, error) {
+	var config OptimizeConfig
+	for _, pass := range strings.Split(passes, ",") {
+		switch strings.TrimSpace(pass) {
+		case "all":
+			config = AllOptimizations
+		case "fold":
+			config.FoldConstants = true
+		case "i":
+			config.EliminateI = true
+		case "combinators":
+			config.Combinators = true
+		case "inline":
+			config.Inline = true
+		case "eta":
+			config.EtaReduce = true
+		case "":
+		default:
+			return config, fmt.Errorf("unknown optimization: '%v'", pass)
+		}
+	}
+	return config, nil
+}
+
+// OptimizeStats tells what Parser.Optimize did.
+type OptimizeStats struct {
+	NodesBefore int // Sum of the NodeCount of the definitions.
+	NodesAfter  int
+	Rewrites    int
+}
+
+// Optimize rewrites the definitions in p.Vars into equivalent ones that take
+// fewer reduction steps, with the passes in config.
+func (p *Parser) Optimize(config OptimizeConfig) OptimizeStats {
+	o := &optimizer{OptimizeConfig: config, vars: p.Vars}
+	stats := OptimizeStats{NodesBefore: o.nodeCount()}
+	// Inlining can make definitions tiny, so it goes on until nothing more is.
+	for {
+		o.findInlined()
+		for name, node := range p.Vars {
+			p.Vars[name] = o.rewrite(node)
+		}
+		if inlined := len(o.inlined); o.findInlined() == inlined {
+			break
+		}
+	}
+	stats.NodesAfter = o.nodeCount()
+	stats.Rewrites = o.rewrites
+	return stats
+}
+
+type optimizer struct {
+	OptimizeConfig
+	vars     map[string]*Node
+	inlined  map[string]bool // Definitions to inline.
+	rewrites int
+}
+
+func (o *optimizer) nodeCount() int {
+	count := 0
+	for _, node := range o.vars {
+		count += node.NodeCount()
+	}
+	return count
+}
+
+// findInlined updates o.inlined and returns its size. Only values are inlined,
+// as copies of other definitions would each be reduced instead of the shared
+// one, and not those that lead back to themselves through the others.
+func (o *optimizer) findInlined() int {
+	o.inlined = make(map[string]bool)
+	if !o.Inline {
+		return 0
+	}
+	for name, node := range o.vars {
+		if isInlinable(node) && node.NodeCount() <= maxInlineNodes {
+			o.inlined[name] = true
+		}
+	}
+	var cyclic []string
+	for name := range o.inlined {
+		if o.reaches(name, name, make(map[string]bool)) {
+			cyclic = append(cyclic, name)
+		}
+	}
+	for _, name := range cyclic {
+		delete(o.inlined, name)
+	}
+	return len(o.inlined)
+}
+
+// reaches reports whether the definition from refers to target, directly or
+// through definitions to inline.
+func (o *optimizer) reaches(from, target string, seen map[string]bool) bool {
+	for _, ref := range o.vars[from].Refs() {
+		if ref == target {
+			return true
+		}
+		if o.inlined[ref] && !seen[ref] {
+			seen[ref] = true
+			if o.reaches(ref, target, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isInlinable reports whether n is in normal form: a number, a builtin, a reference
+// or a builtin applied to fewer values than it takes.
+func isInlinable(n *Node) bool {
+	args := 0
+	for ; n.nodeType == Ap; n = n.fun {
+		if !isInlinable(n.Nodes[0]) {
+			return false
+		}
+		args += 1
+	}
+	switch n.nodeType {
+	case Num:
+		return args == 0 && n.isNum()
+	case Ref:
+		return args == 0
+	case Fun:
+		return args == 0 || args < arities[n.funName]
+	}
+	return false
+}
+
+// rewrite returns n with the passes applied to its subterms, bottom up. Nodes
+// shared within n are shared in the result.
+func (o *optimizer) rewrite(n *Node) *Node {
+	done := make(map[*Node]*Node)
+	// Nodes go on the stack twice, to be rewritten once their children are.
+	type task struct {
+		node     *Node
+		children bool // Whether the children are done.
+	}
+	stack := []task{{n, false}}
+	for len(stack) > 0 {
+		t := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		node := t.node
+		if _, ok := done[node]; ok {
+			continue
+		}
+		if !t.children && node.nodeType != Ref {
+			stack = append(stack, task{node, true})
+			if node.fun != nil {
+				stack = append(stack, task{node.fun, false})
+			}
+			for _, child := range node.Nodes {
+				stack = append(stack, task{child, false})
+			}
+			continue
+		}
+		if node.nodeType == Ref {
+			done[node] = o.simplify(node)
+			continue
+		}
+		clone := *node
+		changed := false
+		if node.fun != nil {
+			clone.fun = done[node.fun]
+			changed = clone.fun != node.fun
+		}
+		clone.Nodes = make([]*Node, len(node.Nodes))
+		for pos, child := range node.Nodes {
+			clone.Nodes[pos] = done[child]
+			changed = changed || clone.Nodes[pos] != child
+		}
+		if changed {
+			node = clone.withFree()
+		}
+		done[t.node] = o.simplify(node)
+	}
+	return done[n]
+}
+
+// simplify applies the passes to n, whose children are simplified already,
+// for as long as one does.
+func (o *optimizer) simplify(n *Node) *Node {
+	for {
+		next := o.step(n)
+		if next == n {
+			return n
+		}
+		o.rewrites += 1
+		n = next
+	}
+}
+
+// step returns the result of the first pass that applies at the top of n, or n.
+func (o *optimizer) step(n *Node) *Node {
+	switch n.nodeType {
+	case Ref:
+		if o.inlined[n.funName] {
+			// The copy is optimized like the definition, whether or not it is yet.
+			return o.rewrite(o.vars[n.funName].Clone())
+		}
+	case Lambda:
+		// The variable of the lambda is NewVar(0) in its body.
+		if body := n.fun; o.EtaReduce && body.nodeType == Ap && body.Nodes[0].nodeType == Var &&
+			body.Nodes[0].num == 0 && !body.fun.hasVar(0) {
+			// The argument is only instantiated for the variables further out.
+			return body.fun.Instantiate(NewFun("i"))
+		}
+	case Ap:
+		fun, arg := n.fun, n.Nodes[0]
+		switch {
+		case o.EliminateI && fun.isFun("i"):
+			return arg
+		case o.FoldConstants && fun.isFun("neg") && arg.isNum():
+			num := negNum(arg)
+			num.pos = n.pos
+			return num
+		case o.FoldConstants && fun.nodeType == Ap && (fun.fun.isFun("add") || fun.fun.isFun("mul")) &&
+			fun.Nodes[0].isNum() && arg.isNum():
+			var num *Node
+			if fun.fun.funName == "add" {
+				num = addNum(fun.Nodes[0], arg)
+			} else {
+				num = mulNum(fun.Nodes[0], arg)
+			}
+			num.pos = n.pos
+			return num
+		case o.Combinators && fun.nodeType == Ap && fun.fun.isFun("b") && arg.isFun("i"):
+			return fun.Nodes[0]
+		case o.Combinators && fun.isFun("c") && arg.nodeType == Ap && arg.fun.isFun("c"):
+			return arg.Nodes[0]
+		case o.EtaReduce && fun.nodeType == Ap && fun.fun.isFun("s") && arg.isFun("i") &&
+			fun.Nodes[0].nodeType == Ap && fun.Nodes[0].fun.isFun("t"):
+			return fun.Nodes[0].Nodes[0]
+		}
+	}
+	return n
+}
+
+// isFun reports whether n is the builtin called name.
+func (n *Node) isFun(name string) bool {
+	return n.nodeType == Fun && n.funName == name
+}
+
+// isNum reports whether n is a number that is not being modulated.
+func (n *Node) isNum() bool {
+	return n.nodeType == Num && n.modulated == ""
+}
+
+// hasVar reports whether the variable NewVar(index) is free in n.
+func (n *Node) hasVar(index int64) bool {
+	type task struct {
+		node  *Node
+		index int64 // Of the variable, as seen from the node.
+	}
+	tasks := []task{{n, index}}
+	for len(tasks) > 0 {
+		t := tasks[len(tasks)-1]
+		tasks = tasks[:len(tasks)-1]
+		if t.node == nil || t.node.free <= t.index {
+			continue
+		}
+		if t.node.nodeType == Var {
+			if t.node.num == t.index {
+				return true
+			}
+			continue
+		}
+		if t.node.nodeType == Lambda {
+			tasks = append(tasks, task{t.node.fun, t.index + 1})
+			continue
+		}
+		tasks = append(tasks, task{t.node.fun, t.index})
+		for _, child := range t.node.Nodes {
+			tasks = append(tasks, task{child, t.index})
+		}
+	}
+	return false
+}
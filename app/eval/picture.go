@@ -0,0 +1,188 @@
+package eval
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Point is a pixel of a Picture.
+type Point struct {
+	X, Y int64
+}
+
+// Picture is a set of points drawn by 'draw' or 'checkerboard'.
+type Picture struct {
+	Points   []Point // In the order they were drawn, without duplicates.
+	Min, Max Point   // Inclusive bounding box. Unset if there are no points.
+}
+
+// maxCheckerboardSize limits the pictures drawn by 'checkerboard'.
+const maxCheckerboardSize = 1024
+
+// maxASCIISize is the largest width and height rendered as ASCII by String.
+const maxASCIISize = 32
+
+// NewPicture returns the picture of points, ignoring duplicates.
+func NewPicture(points []Point) *Picture {
+	picture := &Picture{}
+	seen := make(map[Point]bool)
+	for _, point := range points {
+		if seen[point] {
+			continue
+		}
+		seen[point] = true
+		if len(picture.Points) == 0 {
+			picture.Min, picture.Max = point, point
+		}
+		picture.Points = append(picture.Points, point)
+		if point.X < picture.Min.X {
+			picture.Min.X = point.X
+		}
+		if point.Y < picture.Min.Y {
+			picture.Min.Y = point.Y
+		}
+		if point.X > picture.Max.X {
+			picture.Max.X = point.X
+		}
+		if point.Y > picture.Max.Y {
+			picture.Max.Y = point.Y
+		}
+	}
+	return picture
+}
+
+// span returns the number of values from min to max inclusive, saturating at
+// math.MaxInt64 when that doesn't fit into an int64.
+func span(min, max int64) int64 {
+	if d := uint64(max) - uint64(min); d < math.MaxInt64 {
+		return int64(d) + 1
+	}
+	return math.MaxInt64
+}
+
+// Width returns the width of the bounding box, at most math.MaxInt64.
+func (p *Picture) Width() int64 {
+	if len(p.Points) == 0 {
+		return 0
+	}
+	return span(p.Min.X, p.Max.X)
+}
+
+// Height returns the height of the bounding box, at most math.MaxInt64.
+func (p *Picture) Height() int64 {
+	if len(p.Points) == 0 {
+		return 0
+	}
+	return span(p.Min.Y, p.Max.Y)
+}
+
+// Contains reports whether pt is drawn in p.
+func (p *Picture) Contains(pt Point) bool {
+	for _, point := range p.Points {
+		if point == pt {
+			return true
+		}
+	}
+	return false
+}
+
+// String renders small pictures as ASCII art, one row per line with y growing
+// downwards, and larger ones by their size only.
+func (p *Picture) String() string {
+	if len(p.Points) == 0 {
+		return "<empty picture>"
+	}
+	header := fmt.Sprintf("picture (%v,%v)-(%v,%v)", p.Min.X, p.Min.Y, p.Max.X, p.Max.Y)
+	if p.Width() > maxASCIISize || p.Height() > maxASCIISize {
+		return fmt.Sprintf("<%v of %v points>", header, len(p.Points))
+	}
+	rows := make([][]byte, p.Height())
+	for y := range rows {
+		rows[y] = []byte(strings.Repeat(".", int(p.Width())))
+	}
+	for _, point := range p.Points {
+		rows[point.Y-p.Min.Y][point.X-p.Min.X] = '#'
+	}
+	lines := []string{"<" + header}
+	for _, row := range rows {
+		lines = append(lines, string(row))
+	}
+	return strings.Join(lines, "\n") + "\n>"
+}
+
+// Picture returns the picture held by a Picture node.
+func (n *Node) Picture() (*Picture, bool) {
+	n = n.value()
+	if n == nil || n.nodeType != Pic {
+		return nil, false
+	}
+	return n.picture, true
+}
+
+// Pictures returns the pictures in a reduced list of pictures, such as the
+// result of 'multipledraw'.
+func Pictures(n *Node) ([]*Picture, error) {
+	var pictures []*Picture
+	for {
+		n = n.value()
+		if n == nil {
+			return nil, errors.New("expected list of pictures: <nil>")
+		}
+		if n.nodeType == Fun && n.funName == "nil" {
+			return pictures, nil
+		}
+		if n.nodeType != Cons || len(n.Nodes) != 2 {
+			return nil, errors.New(fmt.Sprintf("expected list of pictures: %v", errorPrint.Sprint(n)))
+		}
+		picture, ok := n.Nodes[0].Picture()
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("expected picture: %v", errorPrint.Sprint(n.Nodes[0])))
+		}
+		pictures = append(pictures, picture)
+		n = n.Nodes[1]
+	}
+}
+
+// vectors returns the points of a reduced list of vectors.
+func vectors(n *Node) ([]Point, bool) {
+	var points []Point
+	for {
+		n = n.value()
+		if n == nil {
+			return nil, false
+		}
+		if n.nodeType == Fun && n.funName == "nil" {
+			return points, true
+		}
+		if n.nodeType != Cons || len(n.Nodes) != 2 {
+			return nil, false
+		}
+		vector := n.Nodes[0].value()
+		if vector == nil || vector.nodeType != Cons || len(vector.Nodes) != 2 {
+			return nil, false
+		}
+		x, okX := vector.Nodes[0].Int64()
+		y, okY := vector.Nodes[1].Int64()
+		if !okX || !okY {
+			return nil, false
+		}
+		points = append(points, Point{x, y})
+		n = n.Nodes[1]
+	}
+}
+
+// checkerboard returns the picture of the squares of a size by size board
+// that have the same color as the one at the origin.
+func checkerboard(size int64) *Picture {
+	var points []Point
+	for y := int64(0); y < size; y += 1 {
+		for x := int64(0); x < size; x += 1 {
+			if (x+y)%2 == 0 {
+				points = append(points, Point{x, y})
+			}
+		}
+	}
+	return NewPicture(points)
+}
@@ -0,0 +1,8 @@
+//go:build race
+// +build race
+
+package eval
+
+// raceEnabled is set when the race detector is on, which makes the deep tests
+// too slow to run in full.
+const raceEnabled = true
@@ -0,0 +1,91 @@
+package eval
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Pos is the position of a token in its input. Lines and columns start from 1,
+// columns count runes. The zero Pos stands for an unknown position.
+type Pos struct {
+	Line, Col int
+}
+
+func (p Pos) IsKnown() bool {
+	return p.Line > 0
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("line %v, column %v", p.Line, p.Col)
+}
+
+// Token is a run of non-whitespace runes.
+type Token struct {
+	Text string
+	Pos  Pos
+}
+
+// Tokenizer splits its input into tokens separated by any whitespace, reading
+// it as it goes.
+type Tokenizer struct {
+	in   *bufio.Reader
+	pos  Pos   // Position of the next rune.
+	last Token // The token returned last.
+	text strings.Builder
+}
+
+func NewTokenizer(r io.Reader) *Tokenizer {
+	return &Tokenizer{in: bufio.NewReader(r), pos: Pos{1, 1}}
+}
+
+// Next returns the next token, or io.EOF at the end of the input.
+func (t *Tokenizer) Next() (Token, error) {
+	t.text.Reset()
+	var start Pos
+	for {
+		r, _, err := t.in.ReadRune()
+		if err == io.EOF && t.text.Len() > 0 {
+			break
+		}
+		if err != nil {
+			return Token{}, err
+		}
+		if unicode.IsSpace(r) {
+			t.advance(r)
+			if t.text.Len() > 0 {
+				break
+			}
+			continue
+		}
+		if t.text.Len() == 0 {
+			start = t.pos
+		}
+		t.text.WriteRune(r)
+		t.advance(r)
+	}
+	t.last = Token{t.text.String(), start}
+	return t.last, nil
+}
+
+// Pos returns the position of the next rune, which is past the end of the
+// input once Next returns io.EOF.
+func (t *Tokenizer) Pos() Pos {
+	return t.pos
+}
+
+// Last returns the token returned by the last call to Next.
+func (t *Tokenizer) Last() Token {
+	return t.last
+}
+
+func (t *Tokenizer) advance(r rune) {
+	if r == '\n' {
+		t.pos.Line += 1
+		t.pos.Col = 1
+	} else {
+		t.pos.Col += 1
+	}
+}
@@ -0,0 +1,181 @@
+package eval
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// WriteNode writes n in the syntax that Parser reads, such as
+// 'ap ap cons 1 nil'. Reduced lists are written as applications of 'cons' and
+// modulated values as applications of 'mod' or 'modlist'. Variables are
+// renamed where their names would be mistaken for others. Closures and
+// pictures have no such syntax and give an error.
+func WriteNode(w io.Writer, n *Node) error {
+	out := bufio.NewWriter(w)
+	if err := writeNode(out, n); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// binding is a variable in scope while writing, and those further out.
+type binding struct {
+	name  string
+	outer *binding
+}
+
+func writeNode(out *bufio.Writer, n *Node) error {
+	type item struct {
+		node  *Node
+		scope *binding
+	}
+	// Functions go on top of the stack, so they are written before arguments.
+	stack := []item{{n, nil}}
+	for first := true; len(stack) > 0; first = false {
+		n, scope := stack[len(stack)-1].node.value(), stack[len(stack)-1].scope
+		stack = stack[:len(stack)-1]
+		if !first {
+			out.WriteByte(' ')
+		}
+		if n == nil {
+			return errors.New("can't write <nil>")
+		}
+		switch n.nodeType {
+		case Ap:
+			if n.fun == nil || len(n.Nodes) != 1 {
+				return errors.New(fmt.Sprintf("can't write malformed application: %v", errorPrint.Sprint(n)))
+			}
+			out.WriteString("ap")
+			stack = append(stack, item{n.Nodes[0], scope}, item{n.fun, scope})
+		case Cons:
+			if len(n.Nodes) != 2 {
+				return errors.New(fmt.Sprintf("can't write malformed cons: %v", errorPrint.Sprint(n)))
+			}
+			out.WriteString("ap ap cons")
+			stack = append(stack, item{n.Nodes[1], scope}, item{n.Nodes[0], scope})
+		case Num:
+			// Modulated values are written as the application that gives them.
+			if n.modulated != "" && n.modulated == modulate(n) {
+				out.WriteString("ap mod")
+				stack = append(stack, item{&Node{nodeType: Num, num: n.num, big: n.big}, scope})
+				break
+			}
+			if n.modulated != "" {
+				list, rest, err := DemodulateList([]byte(n.modulated))
+				if err != nil || len(rest) > 0 {
+					return errors.New(fmt.Sprintf("can't write modulated value: %v", n.modulated))
+				}
+				out.WriteString("ap modlist")
+				stack = append(stack, item{list, scope})
+				break
+			}
+			if n.big != nil {
+				out.WriteString(n.big.String())
+			} else {
+				out.WriteString(strconv.FormatInt(n.num, 10))
+			}
+		case Ref, Fun:
+			out.WriteString(n.funName)
+		case Lambda:
+			name := variableName(n, scope)
+			fmt.Fprintf(out, "\\%v ->", name)
+			stack = append(stack, item{n.fun, &binding{name, scope}})
+		case Var:
+			b := scope
+			for i := int64(0); i < n.num && b != nil; i += 1 {
+				b = b.outer
+			}
+			if b == nil {
+				return errors.New(fmt.Sprintf("can't write free variable #%v", n.num))
+			}
+			out.WriteString(b.name)
+		default:
+			return errors.New(fmt.Sprintf("can't write %v", errorPrint.Sprint(n)))
+		}
+	}
+	return nil
+}
+
+// variableName returns a name for the variable of lambda that differs from
+// those in scope and from the names of references and builtins in its body.
+func variableName(lambda *Node, scope *binding) string {
+	taken := make(map[string]bool)
+	for b := scope; b != nil; b = b.outer {
+		taken[b.name] = true
+	}
+	visited := make(map[*Node]bool)
+	stack := []*Node{lambda.fun}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1].value()
+		stack = stack[:len(stack)-1]
+		if node == nil || visited[node] {
+			continue
+		}
+		visited[node] = true
+		if node.nodeType == Fun || node.nodeType == Ref {
+			taken[node.funName] = true
+			continue
+		}
+		stack = append(stack, node.fun)
+		stack = append(stack, node.Nodes...)
+	}
+	base := lambda.bound
+	if !isVariableName(base) {
+		base = "x"
+	}
+	name := base
+	for i := 1; taken[name]; i += 1 {
+		name = fmt.Sprint(base, i)
+	}
+	return name
+}
+
+// WriteDefinitions writes vars as 'name = expression' lines that Parser reads
+// back into the same trees. They are in the order of DefinitionNames, so names
+// such as 'galaxy' come last as they do in galaxy.txt.
+func WriteDefinitions(w io.Writer, vars map[string]*Node) error {
+	out := bufio.NewWriter(w)
+	for _, name := range DefinitionNames(vars) {
+		out.WriteString(name)
+		out.WriteString(" = ")
+		if err := writeNode(out, vars[name]); err != nil {
+			return errors.New(fmt.Sprintf("%v: %v", name, err))
+		}
+		out.WriteByte('\n')
+	}
+	return out.Flush()
+}
+
+// DefinitionNames returns the names in vars, with numbered definitions first
+// in order and the others sorted after them.
+func DefinitionNames(vars map[string]*Node) []string {
+	var names []string
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		x, xNumbered := definitionNumber(names[i])
+		y, yNumbered := definitionNumber(names[j])
+		if xNumbered != yNumbered {
+			return xNumbered
+		}
+		if xNumbered && x != y {
+			return x < y
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// definitionNumber returns N for definitions named ':N'.
+func definitionNumber(name string) (int64, bool) {
+	if len(name) < 2 || name[0] != ':' {
+		return 0, false
+	}
+	num, err := strconv.ParseInt(name[1:], 10, 64)
+	return num, err == nil
+}
@@ -0,0 +1,234 @@
+// Package explore searches the screens of an interaction protocol breadth
+// first.
+//
+// A screen is a state together with the pictures drawn for it. Starting from
+// some state, the explorer clicks candidate points on every new screen and
+// builds a graph of the distinct screens found and the clicks between them.
+package explore
+
+import (
+	"app/eval"
+	"app/interact"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Screen is a distinct state and pictures reached while exploring.
+type Screen struct {
+	Id       int
+	Hash     string
+	Depth    int          // Number of clicks from the start.
+	Path     []eval.Point // Clicks from the start, of length Depth.
+	State    *eval.Node
+	Pictures []*eval.Picture
+}
+
+// Edge holds the clicks that lead from one screen to another.
+type Edge struct {
+	From, To int
+	Clicks   []eval.Point
+}
+
+// Graph is the outcome of an exploration. Clicks that don't change the screen
+// are left out.
+type Graph struct {
+	Screens []*Screen
+	Edges   []*Edge
+	Clicks  int // Number of interactions evaluated.
+	Errors  int // Number of clicks the protocol failed on.
+}
+
+// Candidates returns the points to click on a screen.
+type Candidates func(screen *Screen) []eval.Point
+
+// Grid returns the points from min to max, both inclusive, step apart.
+func Grid(min, max eval.Point, step int64) Candidates {
+	if step <= 0 {
+		step = 1
+	}
+	var points []eval.Point
+	for y := min.Y; y <= max.Y; y += step {
+		for x := min.X; x <= max.X; x += step {
+			points = append(points, eval.Point{X: x, Y: y})
+		}
+	}
+	return func(*Screen) []eval.Point {
+		return points
+	}
+}
+
+// Pixels returns the points drawn on a screen, or the origin if there are none.
+func Pixels(screen *Screen) []eval.Point {
+	var points []eval.Point
+	seen := make(map[eval.Point]bool)
+	for _, picture := range screen.Pictures {
+		for _, point := range picture.Points {
+			if !seen[point] {
+				seen[point] = true
+				points = append(points, point)
+			}
+		}
+	}
+	if len(points) == 0 {
+		points = append(points, eval.Point{})
+	}
+	return points
+}
+
+// Explorer explores the screens of Protocol.
+type Explorer struct {
+	Driver     *interact.Driver
+	Protocol   *eval.Node
+	Candidates Candidates // Defaults to Pixels.
+	Workers    int        // Number of parallel evaluations. Defaults to 1.
+	MaxDepth   int        // Number of clicks to go from the start. Zero means no limit.
+	MaxScreens int        // Zero means no limit.
+}
+
+// Hash identifies a state and its pictures. Each picture is hashed as a set of
+// points, so the order they were drawn in and any duplicates don't matter.
+func Hash(state *eval.Node, pictures []*eval.Picture) (string, error) {
+	bytes, err := eval.ModulateList(state, nil)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	hash.Write(bytes)
+	for _, picture := range pictures {
+		fmt.Fprintf(hash, "|%v", pointSet(picture.Points))
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// pointSet returns points sorted by Y and then X, without duplicates.
+func pointSet(points []eval.Point) []eval.Point {
+	sorted := append([]eval.Point{}, points...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Y != sorted[j].Y {
+			return sorted[i].Y < sorted[j].Y
+		}
+		return sorted[i].X < sorted[j].X
+	})
+	var set []eval.Point
+	for i, point := range sorted {
+		if i == 0 || point != sorted[i-1] {
+			set = append(set, point)
+		}
+	}
+	return set
+}
+
+type job struct {
+	from   *Screen
+	click  eval.Point
+	result *interact.Result
+	err    error
+}
+
+// Explore searches breadth first from the screen of start, which is drawn
+// without pictures. It returns the graph found so far along with the error
+// if ctx is done early.
+func (e *Explorer) Explore(ctx context.Context, start *eval.Node) (*Graph, error) {
+	candidates := e.Candidates
+	if candidates == nil {
+		candidates = Pixels
+	}
+	hash, err := Hash(start, nil)
+	if err != nil {
+		return nil, err
+	}
+	graph := &Graph{}
+	root := &Screen{Hash: hash, State: start}
+	graph.Screens = append(graph.Screens, root)
+	screens := map[string]*Screen{hash: root}
+	edges := make(map[[2]int]*Edge)
+	level := []*Screen{root}
+	for depth := 0; len(level) > 0 && (e.MaxDepth <= 0 || depth < e.MaxDepth); depth += 1 {
+		var jobs []*job
+		for _, screen := range level {
+			for _, click := range candidates(screen) {
+				jobs = append(jobs, &job{from: screen, click: click})
+			}
+		}
+		e.run(ctx, jobs)
+		// Once ctx is done, the jobs that never ran or were cut short by it are
+		// left out.
+		canceled := ctx.Err()
+		level = nil
+		for _, job := range jobs {
+			if canceled != nil && job.result == nil && (job.err == nil || errors.Is(job.err, canceled)) {
+				continue
+			}
+			graph.Clicks += 1
+			if job.err != nil {
+				graph.Errors += 1
+				continue
+			}
+			hash, err := Hash(job.result.State, job.result.Pictures)
+			if err != nil {
+				graph.Errors += 1
+				continue
+			}
+			to, ok := screens[hash]
+			if !ok {
+				if e.MaxScreens > 0 && len(graph.Screens) >= e.MaxScreens {
+					continue
+				}
+				path := append(append([]eval.Point{}, job.from.Path...), job.click)
+				to = &Screen{Id: len(graph.Screens), Hash: hash, Depth: depth + 1, Path: path,
+					State: job.result.State, Pictures: job.result.Pictures}
+				graph.Screens = append(graph.Screens, to)
+				screens[hash] = to
+				level = append(level, to)
+			}
+			if to == job.from {
+				continue
+			}
+			key := [2]int{job.from.Id, to.Id}
+			edge, ok := edges[key]
+			if !ok {
+				edge = &Edge{From: job.from.Id, To: to.Id}
+				edges[key] = edge
+				graph.Edges = append(graph.Edges, edge)
+			}
+			edge.Clicks = append(edge.Clicks, job.click)
+		}
+		if canceled != nil {
+			return graph, canceled
+		}
+	}
+	return graph, nil
+}
+
+// run evaluates jobs with up to e.Workers in parallel.
+func (e *Explorer) run(ctx context.Context, jobs []*job) {
+	workers := e.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	queue := make(chan *job)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				job.result, job.err = e.Driver.Interact(ctx, e.Protocol, job.from.State,
+					interact.NewVector(job.click))
+			}
+		}()
+	}
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+}
@@ -0,0 +1,192 @@
+package explore
+
+import (
+	"app/eval"
+	"app/interact"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestExplore(t *testing.T) {
+	var parser eval.Parser
+	driver := &interact.Driver{Parser: &parser, Config: eval.ReducerConfig{MaxStepCount: 1000}}
+	tests := []struct {
+		explorer Explorer
+		screens  int
+		edges    string
+		clicks   int
+	}{
+		// Test 0
+		{Explorer{}, 2, "[0->1 [{0 0}]]", 2},
+		// Test 1
+		{Explorer{Candidates: Grid(eval.Point{X: 0, Y: 0}, eval.Point{X: 1, Y: 1}, 1), Workers: 3},
+			5, "[0->1 [{0 0}] 0->2 [{1 0}] 0->3 [{0 1}] 0->4 [{1 1}] 1->2 [{1 0}] 1->3 [{0 1}] 1->4 [{1 1}] " +
+				"2->1 [{0 0}] 2->3 [{0 1}] 2->4 [{1 1}] 3->1 [{0 0}] 3->2 [{1 0}] 3->4 [{1 1}] " +
+				"4->1 [{0 0}] 4->2 [{1 0}] 4->3 [{0 1}]]", 20},
+		// Test 2
+		{Explorer{Candidates: Grid(eval.Point{X: 0, Y: 0}, eval.Point{X: 2, Y: 0}, 2), MaxDepth: 1},
+			3, "[0->1 [{0 0}] 0->2 [{2 0}]]", 2},
+		// Test 3
+		{Explorer{Candidates: Grid(eval.Point{X: 0, Y: 0}, eval.Point{X: 9, Y: 0}, 1), MaxScreens: 4, Workers: 4},
+			4, "[0->1 [{0 0}] 0->2 [{1 0}] 0->3 [{2 0}] 1->2 [{1 0}] 1->3 [{2 0}] 2->1 [{0 0}] 2->3 [{2 0}] " +
+				"3->1 [{0 0}] 3->2 [{1 0}]]", 40},
+	}
+	for testId, test := range tests {
+		explorer := test.explorer
+		explorer.Driver = driver
+		explorer.Protocol = eval.NewFun("statelessdraw")
+		graph, err := explorer.Explore(context.Background(), eval.NewList())
+		if err != nil {
+			t.Errorf("Test %v: Failed to explore: %v", testId, err)
+			continue
+		}
+		var edges []string
+		for _, edge := range graph.Edges {
+			edges = append(edges, fmt.Sprintf("%v->%v %v", edge.From, edge.To, edge.Clicks))
+		}
+		if len(graph.Screens) != test.screens || fmt.Sprint(edges) != test.edges || graph.Clicks != test.clicks {
+			t.Errorf("Test %v: Expected %v screens, %v clicks and edges %v, got: %v, %v, %v", testId,
+				test.screens, test.clicks, test.edges, len(graph.Screens), graph.Clicks, edges)
+		}
+	}
+}
+
+// cancelSender cancels the exploration as soon as anything is sent.
+type cancelSender struct {
+	cancel context.CancelFunc
+}
+
+func (s *cancelSender) Send(ctx context.Context, data *eval.Node) (*eval.Node, error) {
+	s.cancel()
+	return nil, ctx.Err()
+}
+
+func TestExploreCanceled(t *testing.T) {
+	var parser eval.Parser
+	// Draws the clicks, except at 2,0 where it sends and so cancels.
+	protocol, err := parser.Parse(":p = \\s -> \\v -> ap ap ap if0 ap ap add -2 ap car v " +
+		"ap ap cons 1 ap ap cons nil ap ap cons nil nil ap ap statelessdraw s v")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	driver := &interact.Driver{Parser: &parser, Sender: &cancelSender{cancel}}
+	explorer := Explorer{Driver: driver, Protocol: protocol,
+		Candidates: Grid(eval.Point{X: 0, Y: 0}, eval.Point{X: 3, Y: 0}, 1)}
+	graph, err := explorer.Explore(ctx, eval.NewList())
+	if err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got: %v", err)
+	}
+	if len(graph.Screens) != 3 || graph.Clicks != 2 || graph.Errors != 0 {
+		t.Errorf("Expected the 2 screens found before canceling, got: %v screens, %v clicks, %v errors",
+			len(graph.Screens), graph.Clicks, graph.Errors)
+	}
+}
+
+func TestExploreCanceledAfterError(t *testing.T) {
+	var parser eval.Parser
+	// Fails at 1,0 by not returning a list, and sends and so cancels at 2,0.
+	protocol, err := parser.Parse(":p = \\s -> \\v -> ap ap ap if0 ap ap add -2 ap car v " +
+		"ap ap cons 1 ap ap cons nil ap ap cons nil nil " +
+		"ap ap ap if0 ap ap add -1 ap car v 5 ap ap statelessdraw s v")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	driver := &interact.Driver{Parser: &parser, Sender: &cancelSender{cancel}}
+	explorer := Explorer{Driver: driver, Protocol: protocol,
+		Candidates: Grid(eval.Point{X: 0, Y: 0}, eval.Point{X: 3, Y: 0}, 1)}
+	graph, err := explorer.Explore(ctx, eval.NewList())
+	if err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got: %v", err)
+	}
+	if len(graph.Screens) != 2 || graph.Clicks != 2 || graph.Errors != 1 {
+		t.Errorf("Expected the failure before canceling to be counted, got: %v screens, %v clicks, %v errors",
+			len(graph.Screens), graph.Clicks, graph.Errors)
+	}
+}
+
+func TestHash(t *testing.T) {
+	state := eval.NewList(eval.NewNum(1))
+	a, err := Hash(state, []*eval.Picture{{Points: []eval.Point{{X: 1, Y: 0}, {X: 0, Y: 1}, {X: 0, Y: 0}}}})
+	if err != nil {
+		t.Fatalf("Failed to hash: %v", err)
+	}
+	b, err := Hash(state, []*eval.Picture{{Points: []eval.Point{{X: 0, Y: 1}, {X: 0, Y: 0}, {X: 1, Y: 0}, {X: 0, Y: 1}}}})
+	if err != nil {
+		t.Fatalf("Failed to hash: %v", err)
+	}
+	if a != b {
+		t.Errorf("Expected the same hash for points drawn in another order, got: %v and %v", a, b)
+	}
+	c, err := Hash(state, []*eval.Picture{{Points: []eval.Point{{X: 1, Y: 0}, {X: 0, Y: 1}}}})
+	if err != nil {
+		t.Fatalf("Failed to hash: %v", err)
+	}
+	if a == c {
+		t.Errorf("Expected a different hash for different points, got: %v", c)
+	}
+}
+
+func TestExport(t *testing.T) {
+	var parser eval.Parser
+	driver := &interact.Driver{Parser: &parser}
+	explorer := Explorer{Driver: driver, Protocol: eval.NewFun("statelessdraw"),
+		Candidates: Grid(eval.Point{X: 0, Y: 0}, eval.Point{X: 1, Y: 0}, 1), MaxDepth: 1}
+	graph, err := explorer.Explore(context.Background(), eval.NewList())
+	if err != nil {
+		t.Fatalf("Failed to explore: %v", err)
+	}
+	var dot strings.Builder
+	if err := graph.WriteDOT(&dot); err != nil {
+		t.Fatalf("Failed to write DOT: %v", err)
+	}
+	expected := "digraph screens {\n" +
+		"  node [shape=box, fontname=monospace];\n" +
+		"  s0 [label=\"#0\\nnil\"];\n" +
+		"  s1 [label=\"#1\\nnil\"];\n" +
+		"  s2 [label=\"#2\\nnil\"];\n" +
+		"  s0 -> s1 [label=\"0,0\"];\n" +
+		"  s0 -> s2 [label=\"1,0\"];\n" +
+		"}\n"
+	if got := dot.String(); got != expected {
+		t.Errorf("Expected DOT:\n%v\ngot:\n%v", expected, got)
+	}
+	var json strings.Builder
+	if err := graph.WriteJSON(&json); err != nil {
+		t.Fatalf("Failed to write JSON: %v", err)
+	}
+	for _, expected := range []string{`"id": 2`, `"modulated": "00"`, `"clicks": 2`} {
+		if !strings.Contains(json.String(), expected) {
+			t.Errorf("Expected %v in JSON:\n%v", expected, json.String())
+		}
+	}
+}
+
+func TestExploreGalaxy(t *testing.T) {
+	bytes, err := ioutil.ReadFile("../galaxy.txt")
+	if err != nil {
+		t.Skipf("galaxy.txt not available: %v", err)
+	}
+	var parser eval.Parser
+	if _, err := parser.Parse(string(bytes)); err != nil {
+		t.Fatalf("Failed to parse galaxy.txt: %v", err)
+	}
+	explorer := Explorer{Driver: &interact.Driver{Parser: &parser}, Protocol: eval.NewRef("galaxy"),
+		Candidates: Grid(eval.Point{}, eval.Point{}, 1), MaxDepth: 3, Workers: 2}
+	graph, err := explorer.Explore(context.Background(), eval.NewList())
+	if err != nil {
+		t.Fatalf("Failed to explore: %v", err)
+	}
+	if len(graph.Screens) != 4 {
+		t.Fatalf("Expected 4 screens, got: %v", graph)
+	}
+	if got, expected := fmt.Sprint(graph.Screens[3].State), "[ 0 :: [ [ 2 :: nil ] :: [ 0 :: [ nil :: nil ] ] ] ]"; got != expected {
+		t.Errorf("Expected state: %v, got: %v", expected, got)
+	}
+}
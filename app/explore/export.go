@@ -0,0 +1,101 @@
+package explore
+
+import (
+	"app/eval"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxLabelSize limits the length of states in DOT labels.
+const maxLabelSize = 40
+
+type screenJSON struct {
+	Id        int        `json:"id"`
+	Hash      string     `json:"hash"`
+	Depth     int        `json:"depth"`
+	Path      [][2]int64 `json:"path"`
+	State     string     `json:"state"`
+	Modulated string     `json:"modulated"`
+	Layers    []int      `json:"layers"` // Number of points of each picture.
+}
+
+type edgeJSON struct {
+	From   int        `json:"from"`
+	To     int        `json:"to"`
+	Clicks [][2]int64 `json:"clicks"`
+}
+
+type graphJSON struct {
+	Screens []screenJSON `json:"screens"`
+	Edges   []edgeJSON   `json:"edges"`
+	Clicks  int          `json:"clicks"`
+	Errors  int          `json:"errors"`
+}
+
+func points(points []eval.Point) [][2]int64 {
+	out := [][2]int64{}
+	for _, point := range points {
+		out = append(out, [2]int64{point.X, point.Y})
+	}
+	return out
+}
+
+// WriteJSON writes g to w as JSON. Pictures are summarized by their sizes, as
+// following a screen's path reproduces them.
+func (g *Graph) WriteJSON(w io.Writer) error {
+	out := graphJSON{Screens: []screenJSON{}, Edges: []edgeJSON{}, Clicks: g.Clicks, Errors: g.Errors}
+	for _, screen := range g.Screens {
+		modulated, err := eval.ModulateList(screen.State, nil)
+		if err != nil {
+			return err
+		}
+		layers := []int{}
+		for _, picture := range screen.Pictures {
+			layers = append(layers, len(picture.Points))
+		}
+		out.Screens = append(out.Screens, screenJSON{screen.Id, screen.Hash, screen.Depth, points(screen.Path),
+			fmt.Sprint(screen.State), string(modulated), layers})
+	}
+	for _, edge := range g.Edges {
+		out.Edges = append(out.Edges, edgeJSON{edge.From, edge.To, points(edge.Clicks)})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// WriteDOT writes g to w in the Graphviz DOT language. Edges are labeled with
+// their first click and the number of clicks.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "digraph screens {")
+	fmt.Fprintln(out, "  node [shape=box, fontname=monospace];")
+	for _, screen := range g.Screens {
+		state := fmt.Sprint(screen.State)
+		if len(state) > maxLabelSize {
+			state = state[:maxLabelSize] + "..."
+		}
+		fmt.Fprintf(out, "  s%v [label=%q];\n", screen.Id, fmt.Sprintf("#%v\n%v", screen.Id, state))
+	}
+	for _, edge := range g.Edges {
+		label := fmt.Sprintf("%v,%v", edge.Clicks[0].X, edge.Clicks[0].Y)
+		if len(edge.Clicks) > 1 {
+			label += fmt.Sprintf(" (%v clicks)", len(edge.Clicks))
+		}
+		fmt.Fprintf(out, "  s%v -> s%v [label=%q];\n", edge.From, edge.To, label)
+	}
+	fmt.Fprintln(out, "}")
+	return out.Flush()
+}
+
+// String summarizes g, one line per screen.
+func (g *Graph) String() string {
+	lines := []string{fmt.Sprintf("%v screens, %v clicks, %v errors", len(g.Screens), g.Clicks, g.Errors)}
+	for _, screen := range g.Screens {
+		lines = append(lines, fmt.Sprintf("#%v depth %v path %v: %v", screen.Id, screen.Depth, screen.Path, screen.State))
+	}
+	return strings.Join(lines, "\n")
+}
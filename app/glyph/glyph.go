@@ -0,0 +1,306 @@
+// Package glyph reads the numbers and symbols that the aliens draw in
+// pictures.
+//
+// A glyph is a square of n by n bits with a bar of n points above it and
+// another to its left. For numbers the corner between the bars is empty, the
+// bits hold the value with the least significant bit at the top left, row by
+// row, and negative numbers extend the left bar by one point. Symbols fill
+// the corner instead. A modulated strip is a band two points tall whose
+// columns are filled for ones and empty for zeros.
+package glyph
+
+import (
+	"app/eval"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+type Kind int
+
+const (
+	Number Kind = iota
+	Symbol
+	Strip
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Number:
+		return "Number"
+	case Symbol:
+		return "Symbol"
+	case Strip:
+		return "Strip"
+	default:
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+}
+
+// Glyph is a glyph found in a picture.
+type Glyph struct {
+	Kind     Kind
+	Min, Max eval.Point // Inclusive bounding box.
+	Size     int        // Bits per row, for numbers and symbols.
+	Value    *big.Int   // Value of a number, or the bits of a symbol.
+	Bits     string     // Bits of a strip.
+	Text     string     // What the glyph reads as.
+}
+
+func (g *Glyph) String() string {
+	return fmt.Sprintf("(%v,%v)-(%v,%v) %v", g.Min.X, g.Min.Y, g.Max.X, g.Max.Y, g.Text)
+}
+
+// symbol identifies a symbol by its size and bits.
+type symbol struct {
+	size  int
+	value int64
+}
+
+// symbols holds the known symbols, as drawn in the messages of the aliens.
+var symbols = map[symbol]string{
+	{1, 0}:   "ap",
+	{2, 2}:   "t",
+	{2, 3}:   "cdr",
+	{2, 4}:   "car",
+	{2, 5}:   "cons",
+	{2, 6}:   "i",
+	{2, 7}:   "s",
+	{2, 8}:   "f",
+	{2, 10}:  "neg",
+	{2, 11}:  "c",
+	{2, 12}:  "b",
+	{2, 14}:  "nil",
+	{3, 40}:  "div",
+	{3, 146}: "mul",
+	{3, 170}: "mod",
+	{3, 341}: "dem",
+	{3, 365}: "add",
+	{3, 401}: "inc",
+	{3, 416}: "lt",
+	{3, 417}: "dec",
+	{3, 448}: "eq",
+}
+
+// minStripSize is the fewest bits read as a strip, as shorter ones are
+// easily mistaken for lines.
+const minStripSize = 4
+
+// maxStripGap is the most empty columns inside a strip.
+const maxStripGap = 7
+
+// decoder finds glyphs among points.
+type decoder struct {
+	set  map[eval.Point]bool
+	used map[eval.Point]bool
+}
+
+func (d *decoder) filled(x, y int64) bool {
+	return d.set[eval.Point{X: x, Y: y}]
+}
+
+// run returns how many points are filled from x, y on, dx, dy apart.
+func (d *decoder) run(x, y, dx, dy int64) int {
+	count := 0
+	for d.filled(x, y) {
+		count += 1
+		x, y = x+dx, y+dy
+	}
+	return count
+}
+
+// isolated reports whether the border around min and max is empty.
+func (d *decoder) isolated(min, max eval.Point) bool {
+	for x := min.X - 1; x <= max.X+1; x += 1 {
+		if d.filled(x, min.Y-1) || d.filled(x, max.Y+1) {
+			return false
+		}
+	}
+	for y := min.Y; y <= max.Y; y += 1 {
+		if d.filled(min.X-1, y) || d.filled(max.X+1, y) {
+			return false
+		}
+	}
+	return true
+}
+
+// bits returns the value of the n by n bits with top left corner x, y.
+func (d *decoder) bits(x, y int64, n int) *big.Int {
+	value := new(big.Int)
+	for i := 0; i < n*n; i += 1 {
+		if d.filled(x+int64(i%n), y+int64(i/n)) {
+			value.SetBit(value, i, 1)
+		}
+	}
+	return value
+}
+
+// number decodes the number glyph whose top bar starts at x, y.
+func (d *decoder) number(x, y int64) *Glyph {
+	if d.filled(x-1, y) || !d.filled(x-1, y+1) {
+		return nil
+	}
+	n := d.run(x, y, 1, 0)
+	left := d.run(x-1, y+1, 0, 1)
+	if left != n && left != n+1 {
+		return nil
+	}
+	g := &Glyph{Kind: Number, Min: eval.Point{X: x - 1, Y: y},
+		Max: eval.Point{X: x + int64(n) - 1, Y: y + int64(left)}, Size: n}
+	if left == n+1 {
+		// The sign point must stand alone in the bottom row.
+		for i := int64(0); i < int64(n); i += 1 {
+			if d.filled(x+i, g.Max.Y) {
+				return nil
+			}
+		}
+	}
+	if !d.isolated(g.Min, g.Max) {
+		return nil
+	}
+	g.Value = d.bits(x, y+1, n)
+	if left == n+1 {
+		g.Value.Neg(g.Value)
+	}
+	g.Text = g.Value.String()
+	return g
+}
+
+// symbol decodes the symbol glyph with its corner at x, y.
+func (d *decoder) symbol(x, y int64) *Glyph {
+	n := d.run(x+1, y, 1, 0)
+	if n == 0 || d.run(x, y+1, 0, 1) != n {
+		return nil
+	}
+	g := &Glyph{Kind: Symbol, Min: eval.Point{X: x, Y: y}, Max: eval.Point{X: x + int64(n), Y: y + int64(n)}, Size: n}
+	if !d.isolated(g.Min, g.Max) {
+		return nil
+	}
+	g.Value = d.bits(x+1, y+1, n)
+	g.Text = fmt.Sprintf("?%v:%v", n, g.Value)
+	if g.Value.IsInt64() {
+		if name, ok := symbols[symbol{n, g.Value.Int64()}]; ok {
+			g.Text = name
+		}
+	}
+	return g
+}
+
+// strip decodes the strip whose first column is at x, y.
+func (d *decoder) strip(x, y int64) *Glyph {
+	column := func(x int64) (bit, ok bool) {
+		top, bottom := d.filled(x, y), d.filled(x, y+1)
+		return top, top == bottom && !d.filled(x, y-1) && !d.filled(x, y+2)
+	}
+	var bits []byte
+	gap := 0
+	for end := x; gap <= maxStripGap; end += 1 {
+		bit, ok := column(end)
+		if !ok {
+			break
+		}
+		if bit {
+			for ; gap > 0; gap -= 1 {
+				bits = append(bits, '0')
+			}
+			bits = append(bits, '1')
+		} else {
+			gap += 1
+		}
+	}
+	if len(bits) < minStripSize {
+		return nil
+	}
+	g := &Glyph{Kind: Strip, Min: eval.Point{X: x, Y: y}, Max: eval.Point{X: x + int64(len(bits)) - 1, Y: y + 1}}
+	if !d.isolated(g.Min, g.Max) {
+		return nil
+	}
+	// A leading zero can't be seen, so it is assumed when that helps.
+	g.Bits = string(bits)
+	g.Text = "strip " + g.Bits
+	for _, bits := range []string{g.Bits, "0" + g.Bits} {
+		if value, rest, err := eval.DemodulateList([]byte(bits)); err == nil && len(rest) == 0 {
+			g.Bits = bits
+			g.Text = fmt.Sprintf("strip %v", value)
+			break
+		}
+	}
+	return g
+}
+
+// Decode returns the glyphs in picture, from top to bottom and left to right.
+// Points that aren't part of a glyph are ignored.
+func Decode(picture *eval.Picture) []*Glyph {
+	d := &decoder{set: make(map[eval.Point]bool), used: make(map[eval.Point]bool)}
+	points := append([]eval.Point{}, picture.Points...)
+	for _, point := range points {
+		d.set[point] = true
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].Y != points[j].Y {
+			return points[i].Y < points[j].Y
+		}
+		return points[i].X < points[j].X
+	})
+	var glyphs []*Glyph
+	for _, point := range points {
+		if d.used[point] {
+			continue
+		}
+		g := d.number(point.X, point.Y)
+		if g == nil {
+			// Strips go first, as their start looks like a symbol of size 1.
+			g = d.strip(point.X, point.Y)
+		}
+		if g == nil {
+			g = d.symbol(point.X, point.Y)
+		}
+		if g == nil {
+			continue
+		}
+		for y := g.Min.Y; y <= g.Max.Y; y += 1 {
+			for x := g.Min.X; x <= g.Max.X; x += 1 {
+				d.used[eval.Point{X: x, Y: y}] = true
+			}
+		}
+		glyphs = append(glyphs, g)
+	}
+	return glyphs
+}
+
+// Text returns the glyphs in reading order, one line per row of glyphs.
+// Glyphs are in the same row if their bounding boxes overlap vertically.
+func Text(glyphs []*Glyph) string {
+	sorted := append([]*Glyph{}, glyphs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Min.Y < sorted[j].Min.Y
+	})
+	var lines []string
+	var row []*Glyph
+	var bottom int64
+	flush := func() {
+		sort.SliceStable(row, func(i, j int) bool {
+			return row[i].Min.X < row[j].Min.X
+		})
+		var words []string
+		for _, g := range row {
+			words = append(words, g.Text)
+		}
+		lines = append(lines, strings.Join(words, " "))
+		row = nil
+	}
+	for _, g := range sorted {
+		if len(row) > 0 && g.Min.Y > bottom {
+			flush()
+		}
+		if len(row) == 0 || g.Max.Y > bottom {
+			bottom = g.Max.Y
+		}
+		row = append(row, g)
+	}
+	if len(row) > 0 {
+		flush()
+	}
+	return strings.Join(lines, "\n")
+}
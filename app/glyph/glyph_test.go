@@ -0,0 +1,136 @@
+package glyph
+
+import (
+	"app/eval"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// picture parses rows of '#' and '.' with the top left corner at the origin.
+func picture(rows ...string) *eval.Picture {
+	var points []eval.Point
+	for y, row := range rows {
+		for x, c := range row {
+			if c == '#' {
+				points = append(points, eval.Point{X: int64(x), Y: int64(y)})
+			}
+		}
+	}
+	return eval.NewPicture(points)
+}
+
+// number draws v at x, y.
+func number(x, y int64, v int64) []eval.Point {
+	negative := v < 0
+	if negative {
+		v = -v
+	}
+	n := int64(1)
+	for n*n < 63 && v >= 1<<uint(n*n) {
+		n += 1
+	}
+	var points []eval.Point
+	for i := int64(1); i <= n; i += 1 {
+		points = append(points, eval.Point{X: x + i, Y: y}, eval.Point{X: x, Y: y + i})
+	}
+	if negative {
+		points = append(points, eval.Point{X: x, Y: y + n + 1})
+	}
+	for i := int64(0); i < n*n; i += 1 {
+		if v&(1<<uint(i)) != 0 {
+			points = append(points, eval.Point{X: x + 1 + i%n, Y: y + 1 + i/n})
+		}
+	}
+	return points
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		picture  *eval.Picture
+		expected string
+	}{
+		// Test 0
+		{picture(
+			".#",
+			"#."), "[(0,0)-(1,1) 0]"},
+		// Test 1
+		{picture(
+			".##",
+			"#.#",
+			"##.",
+			"#.."), "[(0,0)-(2,3) -6]"},
+		// Test 2
+		{picture(
+			"##",
+			"#."), "[(0,0)-(1,1) ap]"},
+		// Test 3
+		{picture(
+			"###.....####",
+			"#.#.....##.#",
+			"#.......##.#",
+			"........##.#"), "[(0,0)-(2,2) t (8,0)-(11,3) add]"},
+		// Test 4
+		{picture(
+			"##....#",
+			"##....#"), "[(0,0)-(6,1) strip 1]"},
+		// Test 5
+		{picture(
+			"#.#....#",
+			"#.#....#"), "[(0,0)-(7,1) strip -1]"},
+		// Test 6
+		{picture(
+			"####",
+			"#..#",
+			"#..#",
+			"####"), "[(0,0)-(3,3) ?3:484]"},
+		// Test 7
+		{picture(
+			"#.#.#"), "[]"},
+		// Test 8
+		{picture(
+			"####.",
+			"#.#..",
+			"#..#.",
+			"#....",
+			"....."), "[(0,0)-(3,3) ?3:34]"},
+	}
+	for testId, test := range tests {
+		if got := fmt.Sprint(Decode(test.picture)); got != test.expected {
+			t.Errorf("Test %v: Expected: %v, got: %v", testId, test.expected, got)
+		}
+	}
+}
+
+func TestDecodeNumbers(t *testing.T) {
+	values := []int64{0, 1, -1, 2, 7, 15, 16, -255, 256, 1000, 65535, -65536, 1 << 40, -(1 << 62)}
+	var points []eval.Point
+	for pos, v := range values {
+		points = append(points, number(int64(pos)*12, 0, v)...)
+	}
+	glyphs := Decode(eval.NewPicture(points))
+	for _, g := range glyphs {
+		if g.Kind != Number {
+			t.Errorf("Expected a number, got: %v %v", g.Kind, g)
+		}
+	}
+	if got := Text(glyphs); got != strings.Trim(fmt.Sprint(values), "[]") {
+		t.Errorf("Expected: %v, got: %v", values, got)
+	}
+}
+
+func TestText(t *testing.T) {
+	var points []eval.Point
+	points = append(points, number(10, 0, 3)...)
+	points = append(points, number(0, 0, 1)...)
+	points = append(points, number(0, 10, 2)...)
+	if got, expected := Text(Decode(eval.NewPicture(points))), "1 3\n2"; got != expected {
+		t.Errorf("Expected:\n%v\ngot:\n%v", expected, got)
+	}
+}
+
+func TestKindString(t *testing.T) {
+	if got := fmt.Sprint([]Kind{Number, Symbol, Strip, Kind(7)}); got != "[Number Symbol Strip Kind(7)]" {
+		t.Errorf("Unexpected kinds: %v", got)
+	}
+}
@@ -0,0 +1,123 @@
+// Package interact drives the galaxy interaction protocol.
+//
+// A protocol is a function of a state and a clicked vector that returns a list
+// of flag, new state and data. While the flag is non-zero the data is sent to
+// the aliens and their response is fed back to the protocol as the next
+// vector. Once the flag is zero the data is a list of images to draw.
+package interact
+
+import (
+	"app/eval"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Driver runs interactions with protocols defined by Parser.
+type Driver struct {
+	Parser *eval.Parser
+	Sender eval.Sender        // Delivers data while the flag is non-zero. Optional.
+	Config eval.ReducerConfig // Used for every evaluation of the protocol.
+}
+
+// Result is the outcome of an interaction.
+type Result struct {
+	State    *eval.Node      // State to pass to the next interaction.
+	Data     *eval.Node      // The list of images drawn.
+	Pictures []*eval.Picture // Data drawn by 'multipledraw'.
+	Sends    int             // Number of round trips through the Sender.
+	Steps    int             // Reduction steps taken over all rounds.
+}
+
+// Interact applies protocol to state and vector, sending data to the aliens
+// for as long as the protocol asks to.
+func (d *Driver) Interact(ctx context.Context, protocol, state, vector *eval.Node) (*Result, error) {
+	result := &Result{}
+	for {
+		reducer := d.Parser.NewReducerWithConfig(eval.NewAp(eval.NewAp(protocol, state), vector), d.Config)
+		reduced, err := reducer.ReduceRootContext(ctx)
+		result.Steps += reducer.StepCount()
+		if err != nil {
+			return nil, err
+		}
+		items, ok := reduced.Items()
+		if !ok || len(items) != 3 {
+			return nil, errors.New(fmt.Sprintf("expected list of flag, state and data: %v", reduced))
+		}
+		flag, ok := items[0].Int64()
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("expected numeric flag: %v", items[0]))
+		}
+		state, err = eval.Modem(items[1])
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid state: %v", err))
+		}
+		data := items[2]
+		if flag == 0 {
+			result.State = state
+			result.Data = data
+			result.Pictures, err = d.draw(ctx, data)
+			if err != nil {
+				return nil, err
+			}
+			return result, nil
+		}
+		if d.Sender == nil {
+			return nil, errors.New(fmt.Sprintf("protocol wants to send, but there is no sender: %v", data))
+		}
+		if vector, err = d.Sender.Send(ctx, data); err != nil {
+			return nil, err
+		}
+		result.Sends += 1
+	}
+}
+
+// draw evaluates 'multipledraw' on data.
+func (d *Driver) draw(ctx context.Context, data *eval.Node) ([]*eval.Picture, error) {
+	reducer := d.Parser.NewReducerWithConfig(eval.NewAp(eval.NewFun("multipledraw"), data), d.Config)
+	drawn, err := reducer.ReduceRootContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return eval.Pictures(drawn)
+}
+
+// NewVector returns the vector clicked at point.
+func NewVector(point eval.Point) *eval.Node {
+	return eval.NewCons(eval.NewNum(point.X), eval.NewNum(point.Y))
+}
+
+// ParsePoint parses a point written as "x,y".
+func ParsePoint(s string) (eval.Point, error) {
+	coords := strings.Split(strings.TrimSpace(s), ",")
+	if len(coords) != 2 {
+		return eval.Point{}, errors.New(fmt.Sprintf("expected x,y: %q", s))
+	}
+	x, err := strconv.ParseInt(strings.TrimSpace(coords[0]), 10, 64)
+	if err != nil {
+		return eval.Point{}, errors.New(fmt.Sprintf("invalid x in %q: %v", s, err))
+	}
+	y, err := strconv.ParseInt(strings.TrimSpace(coords[1]), 10, 64)
+	if err != nil {
+		return eval.Point{}, errors.New(fmt.Sprintf("invalid y in %q: %v", s, err))
+	}
+	return eval.Point{X: x, Y: y}, nil
+}
+
+// ParsePoints parses a semicolon separated list of points, e.g. "0,0;8,4".
+func ParsePoints(s string) ([]eval.Point, error) {
+	var points []eval.Point
+	for _, field := range strings.Split(s, ";") {
+		if strings.TrimSpace(field) == "" {
+			continue
+		}
+		point, err := ParsePoint(field)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
@@ -0,0 +1,309 @@
+package interact
+
+import (
+	"app/alien"
+	"app/eval"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+type fakeSender struct {
+	sent     []string
+	response *eval.Node
+}
+
+func (s *fakeSender) Send(ctx context.Context, data *eval.Node) (*eval.Node, error) {
+	s.sent = append(s.sent, fmt.Sprint(data))
+	return s.response, nil
+}
+
+func TestInteract(t *testing.T) {
+	var parser eval.Parser
+	// Returns the vector as the protocol's result, so clicks choose what happens.
+	if _, err := parser.Parse(":echo = ap t i"); err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	tests := []struct {
+		protocol *eval.Node
+		vector   *eval.Node
+		response *eval.Node
+		state    string
+		pictures string
+		sent     string
+	}{
+		// Test 0
+		{eval.NewFun("statelessdraw"), NewVector(eval.Point{X: 1, Y: 0}), nil,
+			"nil", "[<picture (1,0)-(1,0)\n#\n>]", "[]"},
+		// Test 1
+		{eval.NewRef(":echo"), eval.NewList(eval.NewNum(0), eval.NewNum(3), eval.NewList(eval.NewList())), nil,
+			"3", "[<empty picture>]", "[]"},
+		// Test 2
+		{eval.NewRef(":echo"), eval.NewList(eval.NewNum(1), eval.NewNum(5), eval.NewNum(42)),
+			eval.NewList(eval.NewNum(0), eval.NewNum(7), eval.NewFun("nil")),
+			"7", "[]", "[42]"},
+	}
+	for testId, test := range tests {
+		sender := &fakeSender{response: test.response}
+		driver := &Driver{Parser: &parser, Sender: sender, Config: eval.ReducerConfig{MaxStepCount: 1000}}
+		result, err := driver.Interact(context.Background(), test.protocol, eval.NewList(), test.vector)
+		if err != nil {
+			t.Errorf("Test %v: Failed to interact: %v", testId, err)
+			continue
+		}
+		if got := fmt.Sprint(result.State); got != test.state {
+			t.Errorf("Test %v: Expected state: %v, got: %v", testId, test.state, got)
+		}
+		if got := fmt.Sprint(result.Pictures); got != test.pictures {
+			t.Errorf("Test %v: Expected pictures: %v, got: %v", testId, test.pictures, got)
+		}
+		if got := fmt.Sprint(sender.sent); got != test.sent || result.Sends != len(sender.sent) {
+			t.Errorf("Test %v: Expected to send: %v, got: %v", testId, test.sent, got)
+		}
+	}
+}
+
+func TestInteractGalaxy(t *testing.T) {
+	bytes, err := ioutil.ReadFile("../galaxy.txt")
+	if err != nil {
+		t.Skipf("galaxy.txt not available: %v", err)
+	}
+	var parser eval.Parser
+	if _, err := parser.Parse(string(bytes)); err != nil {
+		t.Fatalf("Failed to parse galaxy.txt: %v", err)
+	}
+	driver := &Driver{Parser: &parser}
+	result, err := driver.Interact(context.Background(), eval.NewRef("galaxy"), eval.NewList(),
+		NewVector(eval.Point{X: 0, Y: 0}))
+	if err != nil {
+		t.Fatalf("Failed to interact: %v", err)
+	}
+	if got, expected := fmt.Sprint(result.State), "[ 0 :: [ [ 0 :: nil ] :: [ 0 :: [ nil :: nil ] ] ] ]"; got != expected {
+		t.Errorf("Expected state: %v, got: %v", expected, got)
+	}
+	if len(result.Pictures) == 0 || len(result.Pictures[0].Points) == 0 {
+		t.Errorf("Expected the galaxy to draw something, got: %v", result.Pictures)
+	}
+}
+
+func TestInteractGalaxySend(t *testing.T) {
+	bytes, err := ioutil.ReadFile("../galaxy.txt")
+	if err != nil {
+		t.Skipf("galaxy.txt not available: %v", err)
+	}
+	var parser eval.Parser
+	if _, err := parser.Parse(string(bytes)); err != nil {
+		t.Fatalf("Failed to parse galaxy.txt: %v", err)
+	}
+	// Where the galaxy is after the intro, which asks the aliens for the
+	// countdown on the next click.
+	state := eval.NewList(eval.NewNum(2), eval.NewList(eval.NewNum(1), eval.NewNum(-1)), eval.NewNum(0), eval.NewList())
+	fake := alien.NewFakeServer()
+	driver := &Driver{Parser: &parser, Sender: fake}
+	result, err := driver.Interact(context.Background(), eval.NewRef("galaxy"), state, NewVector(eval.Point{X: 0, Y: 0}))
+	if err != nil {
+		t.Fatalf("Failed to interact: %v", err)
+	}
+	if got := fmt.Sprint(fake.Requests()); result.Sends != 1 || got != "[[ 0 :: nil ]]" {
+		t.Errorf("Expected to send a countdown request, got %v sends: %v", result.Sends, got)
+	}
+	if items, ok := result.State.Items(); !ok || len(items) == 0 || fmt.Sprint(items[0]) != "5" {
+		t.Errorf("Expected the galaxy screen, got state: %v", result.State)
+	}
+	if len(result.Pictures) == 0 {
+		t.Errorf("Expected the galaxy to draw something")
+	}
+}
+
+func TestParsePoints(t *testing.T) {
+	tests := []struct {
+		input    string
+		correct  bool
+		expected string
+	}{
+		// Test 0
+		{"0,0", true, "[{0 0}]"},
+		// Test 1
+		{" 8, 4 ; -3,-3;", true, "[{8 4} {-3 -3}]"},
+		// Test 2
+		{"", true, "[]"},
+		// Test 3
+		{"1", false, ""},
+		// Test 4
+		{"1,x", false, ""},
+	}
+	for testId, test := range tests {
+		points, err := ParsePoints(test.input)
+		if correct := err == nil; correct != test.correct {
+			t.Errorf("Test %v: Expected correct: %v, got: %v", testId, test.correct, err)
+			continue
+		}
+		if got := fmt.Sprint(points); test.correct && got != test.expected {
+			t.Errorf("Test %v: Expected: %v, got: %v", testId, test.expected, got)
+		}
+	}
+}
+
+func TestSession(t *testing.T) {
+	bytes, err := ioutil.ReadFile("../galaxy.txt")
+	if err != nil {
+		t.Skipf("galaxy.txt not available: %v", err)
+	}
+	var parser eval.Parser
+	if _, err := parser.Parse(string(bytes)); err != nil {
+		t.Fatalf("Failed to parse galaxy.txt: %v", err)
+	}
+	driver := &Driver{Parser: &parser}
+	ctx := context.Background()
+	session := &Session{Protocol: "galaxy"}
+	for _, click := range []eval.Point{{X: 0, Y: 0}, {X: 0, Y: 0}, {X: 0, Y: 0}} {
+		state, _ := session.State(len(session.Steps))
+		result, err := driver.Interact(ctx, eval.NewRef("galaxy"), state, NewVector(click))
+		if err != nil {
+			t.Fatalf("Failed to interact: %v", err)
+		}
+		session.Record(state, click, result)
+	}
+	var saved strings.Builder
+	if err := session.Save(&saved); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+	loaded, err := LoadSession(strings.NewReader(saved.String()))
+	if err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+	if loaded.Protocol != "galaxy" || len(loaded.Steps) != 3 {
+		t.Fatalf("Expected 3 steps of galaxy, got: %v %v", loaded.Protocol, len(loaded.Steps))
+	}
+	for pos, step := range loaded.Steps {
+		expected := session.Steps[pos]
+		if fmt.Sprint(step.Click, step.Before, step.After, step.Pictures) !=
+			fmt.Sprint(expected.Click, expected.Before, expected.After, expected.Pictures) {
+			t.Errorf("Step %v: Expected: %v, got: %v", pos, expected, step)
+		}
+	}
+	if state, err := loaded.State(2); err != nil || fmt.Sprint(state) != "[ 0 :: [ [ 1 :: nil ] :: [ 0 :: [ nil :: nil ] ] ] ]" {
+		t.Errorf("Unexpected state after 2 steps: %v %v", state, err)
+	}
+	if _, err := loaded.State(4); err == nil {
+		t.Errorf("Expected no state after 4 steps")
+	}
+	replay, err := driver.Replay(ctx, eval.NewRef("galaxy"), loaded, 3)
+	if err != nil {
+		t.Fatalf("Failed to replay: %v", err)
+	}
+	if fmt.Sprint(replay.Steps[2].After) != fmt.Sprint(session.Steps[2].After) {
+		t.Errorf("Expected replay to end in: %v, got: %v", session.Steps[2].After, replay.Steps[2].After)
+	}
+	loaded.Steps[1].After = eval.NewList()
+	if _, err := driver.Replay(ctx, eval.NewRef("galaxy"), loaded, 3); err == nil ||
+		!strings.Contains(err.Error(), "step 1 at 0,0: expected state: nil") {
+		t.Errorf("Expected replay to diverge at step 1, got: %v", err)
+	}
+}
+
+func TestLoadSession(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// Test 0
+		{`{"protocol": "galaxy", "steps": []}`, ""},
+		// Test 1
+		{`{"steps": [{"click": [1, 2], "before": "00", "after": "110110000100", "pictures": [[[1, 2]]]}]}`, ""},
+		// Test 2
+		{`{"steps": [`, "invalid session"},
+		// Test 3
+		{`{"steps": [{"click": [1, 2], "before": "2", "after": "00"}]}`, "step 0: invalid state before"},
+		// Test 4
+		{`{"steps": [{"click": [1, 2], "before": "00", "after": "0000"}]}`, "step 0: invalid state after: trailing data"},
+	}
+	for testId, test := range tests {
+		_, err := LoadSession(strings.NewReader(test.input))
+		if err == nil && test.expected != "" || err != nil && (test.expected == "" || !strings.Contains(err.Error(), test.expected)) {
+			t.Errorf("Test %v: Expected error: %q, got: %v", testId, test.expected, err)
+		}
+	}
+}
+
+func TestScript(t *testing.T) {
+	var parser eval.Parser
+	driver := &Driver{Parser: &parser, Config: eval.ReducerConfig{MaxStepCount: 1000}}
+	tests := []struct {
+		script string
+		clicks int
+		err    string
+	}{
+		// Test 0
+		{"# Draws the clicks.\nclick 1,2\n\nassert pixel 1,2 layer 0\nassert pictures 1\nassert state = nil", 1, ""},
+		// Test 1
+		{"click 1,2 3\nassert pixel 1,2", 3, ""},
+		// Test 2
+		{"click 1,2\nassert pixel 3,3", 1, "line 2: assert pixel 3,3: 3,3 not drawn"},
+		// Test 3
+		{"click 1,2\nassert pixel 1,2 layer 1", 1, "line 2: assert pixel 1,2 layer 1: 1,2 not drawn in picture 1 of 1"},
+		// Test 4
+		{"wait 4,4 pixel 4,4", 1, ""},
+		// Test 5
+		{"wait 4,4 pixel 5,5 max 3", 3, "line 1: wait 4,4 pixel 5,5 max 3: gave up after 3 clicks: 5,5 not drawn"},
+		// Test 6
+		{"assert pictures 0\nassert state.0 = 1", 0, "line 2: assert state.0 = 1: no item 0 in nil"},
+		// Test 7
+		{"assert state = ap ap cons 1 nil", 0, "line 1: assert state = ap ap cons 1 nil: expected: [ 1 :: nil ], got: nil"},
+	}
+	for testId, test := range tests {
+		script, err := ParseScript(strings.NewReader(test.script))
+		if err != nil {
+			t.Errorf("Test %v: Failed to parse: %v", testId, err)
+			continue
+		}
+		session := &Session{}
+		_, err = driver.RunScript(context.Background(), eval.NewFun("statelessdraw"), script, session)
+		if got := fmt.Sprint(err); err != nil && got != test.err || err == nil && test.err != "" {
+			t.Errorf("Test %v: Expected error: %q, got: %v", testId, test.err, err)
+		}
+		if len(session.Steps) != test.clicks {
+			t.Errorf("Test %v: Expected %v clicks, got: %v", testId, test.clicks, len(session.Steps))
+		}
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	tests := []struct {
+		script string
+		err    string
+	}{
+		// Test 0
+		{"jump 1,2", `line 1: unknown command: "jump"`},
+		// Test 1
+		{"\nclick 1", `line 2: expected x,y: "1"`},
+		// Test 2
+		{"click 1,2 many", `line 1: invalid number of clicks: "many"`},
+		// Test 3
+		{"wait 1,2 pixel 3,3 max x", `line 1: invalid number of clicks: "x"`},
+		// Test 4
+		{"assert", "line 1: missing condition"},
+		// Test 5
+		{"assert state.x = 1", `line 1: invalid index: "x"`},
+		// Test 6
+		{"assert state 1", "line 1: expected: state[.INDEX...] = EXPR"},
+		// Test 7
+		{"assert state = ap 1", "line 1: incomplete expression: ap 1"},
+		// Test 8
+		{"assert pixel 1,2 layer", "line 1: expected: pixel X,Y [layer N]"},
+		// Test 9
+		{"assert color red", `line 1: unknown condition: "color"`},
+		// Test 10
+		{"click 1,2\n\nwait 1,2  state =  ap inc =", "line 3: unexpected '='"},
+		// Test 11
+		{"assert state = 1 2", "line 1: unparsed leftover '2'"},
+	}
+	for testId, test := range tests {
+		_, err := ParseScript(strings.NewReader(test.script))
+		if got := fmt.Sprint(err); got != test.err {
+			t.Errorf("Test %v: Expected error: %q, got: %v", testId, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package interact
+
+import (
+	"app/eval"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A script is a macro of clicks and checks, one command per line:
+//
+//	# Comments and empty lines are skipped.
+//	click 0,0                   Click at 0,0.
+//	click 0,0 8                 Click at 0,0 8 times.
+//	wait 0,0 pixel -1,-3        Click at 0,0 until a condition holds, at most
+//	wait 0,0 pictures 2 max 20  100 times unless max says otherwise.
+//	assert state.1.0 = 4        Fail unless a condition holds.
+//
+// Conditions are one of:
+//
+//	state = EXPR                The state equals EXPR, e.g. 'ap ap cons 1 nil'.
+//	state.1.0 = EXPR            Item 0 of item 1 of the state equals EXPR.
+//	pixel X,Y                   Any picture draws X,Y.
+//	pixel X,Y layer N           Picture N draws X,Y.
+//	pictures N                  There are N pictures.
+
+// defaultMaxWait is how many clicks 'wait' makes by default.
+const defaultMaxWait = 100
+
+// Screen is the outcome of the last click.
+type Screen struct {
+	State    *eval.Node
+	Pictures []*eval.Picture
+}
+
+// condition is a check on a screen, which returns why it doesn't hold.
+type condition interface {
+	check(screen *Screen) error
+}
+
+type stateCondition struct {
+	path  []int
+	value *eval.Node
+}
+
+func (c *stateCondition) check(screen *Screen) error {
+	n := screen.State
+	for _, index := range c.path {
+		items, ok := n.Items()
+		if !ok || index >= len(items) {
+			return errors.New(fmt.Sprintf("no item %v in %v", index, n))
+		}
+		n = items[index]
+	}
+	if got, expected := fmt.Sprint(n), fmt.Sprint(c.value); got != expected {
+		return errors.New(fmt.Sprintf("expected: %v, got: %v", expected, got))
+	}
+	return nil
+}
+
+type pixelCondition struct {
+	point eval.Point
+	layer int // Negative for any.
+}
+
+func (c *pixelCondition) check(screen *Screen) error {
+	for pos, picture := range screen.Pictures {
+		if (c.layer < 0 || c.layer == pos) && picture.Contains(c.point) {
+			return nil
+		}
+	}
+	if c.layer >= 0 {
+		return errors.New(fmt.Sprintf("%v,%v not drawn in picture %v of %v",
+			c.point.X, c.point.Y, c.layer, len(screen.Pictures)))
+	}
+	return errors.New(fmt.Sprintf("%v,%v not drawn", c.point.X, c.point.Y))
+}
+
+type picturesCondition struct {
+	count int
+}
+
+func (c *picturesCondition) check(screen *Screen) error {
+	if len(screen.Pictures) != c.count {
+		return errors.New(fmt.Sprintf("expected %v pictures, got: %v", c.count, len(screen.Pictures)))
+	}
+	return nil
+}
+
+// Command is a line of a script.
+type Command struct {
+	Line  int    // Line number, starting from 1.
+	Text  string // The line itself.
+	Click *eval.Point
+	Times int // Number of clicks, or the most to wait for.
+	cond  condition
+}
+
+// Script is a parsed macro.
+type Script struct {
+	Commands []*Command
+}
+
+// ScriptError is a command that failed.
+type ScriptError struct {
+	Command *Command
+	Err     error
+}
+
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("line %v: %v: %v", e.Command.Line, e.Command.Text, e.Err)
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+// ParseScript reads a script.
+func ParseScript(r io.Reader) (*Script, error) {
+	script := &Script{}
+	scanner := bufio.NewScanner(r)
+	for row := 1; scanner.Scan(); row += 1 {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		command, err := parseCommand(text)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("line %v: %v", row, err))
+		}
+		command.Line = row
+		command.Text = text
+		script.Commands = append(script.Commands, command)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return script, nil
+}
+
+func parseCommand(text string) (*Command, error) {
+	fields := strings.Fields(text)
+	switch fields[0] {
+	case "click":
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, errors.New("expected: click X,Y [TIMES]")
+		}
+		click, err := ParsePoint(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		command := &Command{Click: &click, Times: 1}
+		if len(fields) == 3 {
+			if command.Times, err = strconv.Atoi(fields[2]); err != nil || command.Times < 0 {
+				return nil, errors.New(fmt.Sprintf("invalid number of clicks: %q", fields[2]))
+			}
+		}
+		return command, nil
+	case "wait":
+		if len(fields) < 3 {
+			return nil, errors.New("expected: wait X,Y CONDITION [max N]")
+		}
+		click, err := ParsePoint(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		command := &Command{Click: &click, Times: defaultMaxWait}
+		fields = fields[2:]
+		if len(fields) > 2 && fields[len(fields)-2] == "max" {
+			if command.Times, err = strconv.Atoi(fields[len(fields)-1]); err != nil || command.Times < 0 {
+				return nil, errors.New(fmt.Sprintf("invalid number of clicks: %q", fields[len(fields)-1]))
+			}
+			fields = fields[:len(fields)-2]
+		}
+		command.cond, err = parseCondition(fields)
+		return command, err
+	case "assert":
+		cond, err := parseCondition(fields[1:])
+		return &Command{cond: cond}, err
+	}
+	return nil, errors.New(fmt.Sprintf("unknown command: %q", fields[0]))
+}
+
+func parseCondition(fields []string) (condition, error) {
+	if len(fields) == 0 {
+		return nil, errors.New("missing condition")
+	}
+	switch {
+	case fields[0] == "state" || strings.HasPrefix(fields[0], "state."):
+		if len(fields) < 3 || fields[1] != "=" {
+			return nil, errors.New("expected: state[.INDEX...] = EXPR")
+		}
+		cond := &stateCondition{}
+		for _, index := range strings.Split(fields[0], ".")[1:] {
+			pos, err := strconv.Atoi(index)
+			if err != nil || pos < 0 {
+				return nil, errors.New(fmt.Sprintf("invalid index: %q", index))
+			}
+			cond.path = append(cond.path, pos)
+		}
+		value, err := parseValue(fields[2:])
+		if err != nil {
+			return nil, err
+		}
+		cond.value = value
+		return cond, nil
+	case fields[0] == "pixel":
+		if len(fields) != 2 && (len(fields) != 4 || fields[2] != "layer") {
+			return nil, errors.New("expected: pixel X,Y [layer N]")
+		}
+		point, err := ParsePoint(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		cond := &pixelCondition{point: point, layer: -1}
+		if len(fields) == 4 {
+			if cond.layer, err = strconv.Atoi(fields[3]); err != nil || cond.layer < 0 {
+				return nil, errors.New(fmt.Sprintf("invalid layer: %q", fields[3]))
+			}
+		}
+		return cond, nil
+	case fields[0] == "pictures":
+		if len(fields) != 2 {
+			return nil, errors.New("expected: pictures N")
+		}
+		count, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid number of pictures: %q", fields[1]))
+		}
+		return &picturesCondition{count}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown condition: %q", fields[0]))
+}
+
+// parseValue evaluates the tokens of an expression to a number or list.
+// Positions within the joined tokens would be misread as ones in the script,
+// so parse errors only name the offending token.
+func parseValue(tokens []string) (*eval.Node, error) {
+	var parser eval.Parser
+	expr := strings.Join(tokens, " ")
+	t := eval.NewTokenizer(strings.NewReader(expr))
+	node, err := parser.ParseExpr(t)
+	if err != nil {
+		var parseErr *eval.ParseError
+		if !errors.As(err, &parseErr) {
+			return nil, err
+		}
+		if parseErr.Token == "" {
+			return nil, errors.New(fmt.Sprintf("incomplete expression: %v", expr))
+		}
+		return nil, errors.New(parseErr.Msg)
+	}
+	if extra, err := t.Next(); err != io.EOF {
+		return nil, errors.New(fmt.Sprintf("unparsed leftover '%v'", extra.Text))
+	}
+	value, err := parser.NewReducerWithConfig(node, eval.ReducerConfig{MaxStepCount: 10000}).ReduceRoot()
+	if err != nil {
+		return nil, err
+	}
+	return eval.Modem(value)
+}
+
+// RunScript runs script, continuing session and recording the clicks in it.
+// It stops at the first command that fails, with a ScriptError.
+func (d *Driver) RunScript(ctx context.Context, protocol *eval.Node, script *Script, session *Session) (*Screen, error) {
+	screen := &Screen{}
+	screen.State, _ = session.State(len(session.Steps))
+	if len(session.Steps) > 0 {
+		screen.Pictures = session.Steps[len(session.Steps)-1].Pictures
+	}
+	click := func(point eval.Point) error {
+		result, err := d.Interact(ctx, protocol, screen.State, NewVector(point))
+		if err != nil {
+			return err
+		}
+		session.Record(screen.State, point, result)
+		screen.State, screen.Pictures = result.State, result.Pictures
+		return nil
+	}
+	wait := func(command *Command) error {
+		for clicks := 0; ; clicks += 1 {
+			err := command.cond.check(screen)
+			if err == nil {
+				return nil
+			}
+			if clicks == command.Times {
+				return errors.New(fmt.Sprintf("gave up after %v clicks: %v", clicks, err))
+			}
+			if err := click(*command.Click); err != nil {
+				return err
+			}
+		}
+	}
+	for _, command := range script.Commands {
+		var err error
+		switch {
+		case command.cond == nil:
+			for i := 0; i < command.Times && err == nil; i += 1 {
+				err = click(*command.Click)
+			}
+		case command.Click == nil:
+			err = command.cond.check(screen)
+		default:
+			err = wait(command)
+		}
+		if err != nil {
+			return screen, &ScriptError{command, err}
+		}
+	}
+	return screen, nil
+}
@@ -0,0 +1,169 @@
+package interact
+
+import (
+	"app/eval"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Step is a click recorded in a Session.
+type Step struct {
+	Click    eval.Point
+	Before   *eval.Node // State clicked on.
+	After    *eval.Node // State returned by the protocol.
+	Pictures []*eval.Picture
+}
+
+// Session is a sequence of clicks starting from the empty state, where each
+// click is made on the state returned by the one before.
+type Session struct {
+	Protocol string // Name of the protocol, e.g. "galaxy".
+	Steps    []*Step
+}
+
+// Record appends a click on before and its result.
+func (s *Session) Record(before *eval.Node, click eval.Point, result *Result) {
+	s.Steps = append(s.Steps, &Step{Click: click, Before: before, After: result.State, Pictures: result.Pictures})
+}
+
+// Truncate drops every step after the first n.
+func (s *Session) Truncate(n int) {
+	if n < len(s.Steps) {
+		s.Steps = s.Steps[:n]
+	}
+}
+
+// State returns the state after the first n steps, to resume from.
+func (s *Session) State(n int) (*eval.Node, error) {
+	if n < 0 || n > len(s.Steps) {
+		return nil, errors.New(fmt.Sprintf("no step %v in session of %v", n, len(s.Steps)))
+	}
+	if n == 0 {
+		return eval.NewList(), nil
+	}
+	return s.Steps[n-1].After, nil
+}
+
+// Replay clicks through the first n steps of s from the empty state and
+// returns the session recorded on the way. It fails as soon as a state differs
+// from the one in s.
+func (d *Driver) Replay(ctx context.Context, protocol *eval.Node, s *Session, n int) (*Session, error) {
+	if n < 0 || n > len(s.Steps) {
+		return nil, errors.New(fmt.Sprintf("no step %v in session of %v", n, len(s.Steps)))
+	}
+	replay := &Session{Protocol: s.Protocol}
+	state := eval.NewList()
+	for pos, step := range s.Steps[:n] {
+		result, err := d.Interact(ctx, protocol, state, NewVector(step.Click))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("step %v at %v,%v: %v", pos, step.Click.X, step.Click.Y, err))
+		}
+		if got, expected := fmt.Sprint(result.State), fmt.Sprint(step.After); got != expected {
+			return nil, errors.New(fmt.Sprintf("step %v at %v,%v: expected state: %v, got: %v",
+				pos, step.Click.X, step.Click.Y, expected, got))
+		}
+		replay.Record(state, step.Click, result)
+		state = result.State
+	}
+	return replay, nil
+}
+
+type stepJSON struct {
+	Click    [2]int64     `json:"click"`
+	Before   string       `json:"before"`
+	After    string       `json:"after"`
+	Pictures [][][2]int64 `json:"pictures"`
+}
+
+type sessionJSON struct {
+	Protocol string     `json:"protocol"`
+	Steps    []stepJSON `json:"steps"`
+}
+
+// Save writes s to w as JSON, with modulated states.
+func (s *Session) Save(w io.Writer) error {
+	out := sessionJSON{Protocol: s.Protocol, Steps: []stepJSON{}}
+	for pos, step := range s.Steps {
+		before, err := eval.ModulateList(step.Before, nil)
+		if err != nil {
+			return errors.New(fmt.Sprintf("step %v: invalid state: %v", pos, err))
+		}
+		after, err := eval.ModulateList(step.After, nil)
+		if err != nil {
+			return errors.New(fmt.Sprintf("step %v: invalid state: %v", pos, err))
+		}
+		pictures := [][][2]int64{}
+		for _, picture := range step.Pictures {
+			points := [][2]int64{}
+			for _, point := range picture.Points {
+				points = append(points, [2]int64{point.X, point.Y})
+			}
+			pictures = append(pictures, points)
+		}
+		out.Steps = append(out.Steps, stepJSON{[2]int64{step.Click.X, step.Click.Y},
+			string(before), string(after), pictures})
+	}
+	// One line per step keeps files readable despite the pictures.
+	protocol, err := json.Marshal(out.Protocol)
+	if err != nil {
+		return err
+	}
+	lines := []string{fmt.Sprintf("{\"protocol\": %s, \"steps\": [", protocol)}
+	for pos, step := range out.Steps {
+		line, err := json.Marshal(step)
+		if err != nil {
+			return err
+		}
+		if pos+1 < len(out.Steps) {
+			line = append(line, ',')
+		}
+		lines = append(lines, string(line))
+	}
+	lines = append(lines, "]}\n")
+	_, err = io.WriteString(w, strings.Join(lines, "\n"))
+	return err
+}
+
+// LoadSession reads a session written by Save.
+func LoadSession(r io.Reader) (*Session, error) {
+	var in sessionJSON
+	if err := json.NewDecoder(r).Decode(&in); err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid session: %v", err))
+	}
+	s := &Session{Protocol: in.Protocol}
+	for pos, step := range in.Steps {
+		before, err := demodulateState(step.Before)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("step %v: invalid state before: %v", pos, err))
+		}
+		after, err := demodulateState(step.After)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("step %v: invalid state after: %v", pos, err))
+		}
+		var pictures []*eval.Picture
+		for _, points := range step.Pictures {
+			var picture []eval.Point
+			for _, point := range points {
+				picture = append(picture, eval.Point{X: point[0], Y: point[1]})
+			}
+			pictures = append(pictures, eval.NewPicture(picture))
+		}
+		s.Steps = append(s.Steps, &Step{eval.Point{X: step.Click[0], Y: step.Click[1]}, before, after, pictures})
+	}
+	return s, nil
+}
+
+func demodulateState(s string) (*eval.Node, error) {
+	state, rest, err := eval.DemodulateList([]byte(s))
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New(fmt.Sprintf("trailing data: %q", rest))
+	}
+	return state, nil
+}
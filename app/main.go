@@ -1,57 +1,602 @@
 package main
 
 import (
+	"app/alien"
+	"app/analysis"
+	"app/compile"
+	"app/decompile"
 	"app/eval"
+	"app/explore"
+	"app/glyph"
+	"app/interact"
+	"app/render"
+	"app/viewer"
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"runtime"
 	"strings"
+	"time"
 )
 
+// newContext returns a context that is canceled on interrupt or once timeout
+// passes, unless it is zero. Canceling it stops catching interrupts.
+func newContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		select {
+		case <-interrupts:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(interrupts)
+		cancel()
+	}
+}
+
+// writeFile creates filename and fills it using write.
+func writeFile(filename string, write func(w io.Writer) error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatalln("Failed to create file: ", filename, "  error: ", err)
+	}
+	if err := write(file); err != nil {
+		log.Fatalln("Failed to write file: ", filename, "  error: ", err)
+	}
+	if err := file.Close(); err != nil {
+		log.Fatalln("Failed to close file: ", filename, "  error: ", err)
+	}
+}
+
+// parseFile reads and parses the definitions in filename.
+func parseFile(filename string) *eval.Parser {
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Fatalln("Failed to read file: ", filename, "  error: ", err)
+	}
+	defer file.Close()
+	parser := &eval.Parser{}
+	if _, err := parser.ParseReader(file); err != nil {
+		log.Fatalln("Failed to parse file: ", filename, "  error: ", err)
+	}
+	_, ioErr := fmt.Fprintf(os.Stderr, "Parse finished. Variables: %v  Nodes: %v  Recursive Definitions: %v\n",
+		len(parser.Vars), parser.NodeCount, parser.RecursiveCount)
+	if ioErr != nil {
+		// Do nothing.
+	}
+	return parser
+}
+
+// screenOutput says where to draw pictures.
+type screenOutput struct {
+	png, svg string // Filenames, optional.
+	terminal bool   // Draw on the terminal rather than print as ASCII.
+	axes     bool
+	glyphs   bool // Decode and annotate glyphs.
+}
+
+// printScreen prints state and draws pictures to output.
+func printScreen(state *eval.Node, pictures []*eval.Picture, printOptions eval.PrintOptions, output screenOutput) {
+	fmt.Printf("state: %v\n", printOptions.Sprint(state))
+	if bytes, err := eval.ModulateList(state, []byte{}); err == nil {
+		fmt.Printf("modulated state: %v\n", string(bytes))
+	}
+	renderOptions := render.Options{Axes: output.axes, Color: true}
+	if output.glyphs {
+		for pos, picture := range pictures {
+			glyphs := glyph.Decode(picture)
+			if len(glyphs) == 0 {
+				continue
+			}
+			fmt.Printf("glyphs in picture %v:\n%v\n", pos, glyph.Text(glyphs))
+			for _, g := range glyphs {
+				renderOptions.Annotations = append(renderOptions.Annotations,
+					render.Annotation{Min: g.Min, Max: g.Max, Text: g.Text})
+			}
+		}
+	}
+	if len(output.png) > 0 {
+		writeFile(output.png, func(w io.Writer) error { return render.PNG(w, pictures, renderOptions) })
+	}
+	if len(output.svg) > 0 {
+		writeFile(output.svg, func(w io.Writer) error { return render.SVG(w, pictures, renderOptions) })
+	}
+	if output.terminal {
+		if err := render.Terminal(os.Stdout, pictures, renderOptions); err != nil {
+			log.Fatalf("Failed to draw pictures: %v", err)
+		}
+		return
+	}
+	for pos, picture := range pictures {
+		fmt.Printf("picture %v: %v\n", pos, picture)
+	}
+}
+
+// readSession loads the session in filename, which must be one of protocolId.
+func readSession(filename, protocolId string) *interact.Session {
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Fatalln("Failed to open file: ", filename, "  error: ", err)
+	}
+	session, err := interact.LoadSession(file)
+	if err != nil {
+		log.Fatalln("Failed to load session: ", filename, "  error: ", err)
+	}
+	if err := file.Close(); err != nil {
+		log.Fatalln("Failed to close file: ", filename, "  error: ", err)
+	}
+	if session.Protocol != protocolId {
+		log.Fatalf("Session '%v' is of protocol '%v', not '%v'\n", filename, session.Protocol, protocolId)
+	}
+	return session
+}
+
+// newSender returns where to send data to when interacting, if anywhere.
+func newSender(fakeAliens bool, alienURL, apiKey string) eval.Sender {
+	switch {
+	case fakeAliens:
+		return alien.NewFakeServer()
+	case len(alienURL) > 0:
+		return &alien.Client{URL: alienURL, APIKey: apiKey, Timeout: 10 * time.Second,
+			Retries: 3, RetryDelay: time.Second}
+	}
+	return nil
+}
+
+// runServe runs the web viewer, e.g. 'app serve -input_file galaxy.txt'.
+func runServe(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	inputFile := flags.String("input_file", "galaxy.txt",
+		"Filename to parse expressions from.")
+	protocolId := flags.String("protocol", "galaxy",
+		"Name of the protocol to interact with.")
+	addr := flags.String("addr", "localhost:8080",
+		"Address to serve the viewer on.")
+	maxSteps := flags.Int("max_steps", 0,
+		"Stop evaluating after this many steps. Zero means no limit.")
+	alienURL := flags.String("alien_url", "",
+		"Address of the aliens' server to send data to.")
+	apiKey := flags.String("api_key", "",
+		"API key for the aliens' server.")
+	fakeAliens := flags.Bool("fake_aliens", false,
+		"Send data to a local stand-in for the aliens' server.")
+	sessionFile := flags.String("session", "",
+		"Filename of a session to resume from.")
+	if err := flags.Parse(args); err != nil {
+		log.Fatalln(err)
+	}
+	parser := parseFile(*inputFile)
+	if _, ok := parser.Vars[*protocolId]; !ok {
+		log.Fatalf("Unknown variable: '%v'\n", *protocolId)
+	}
+	driver := &interact.Driver{Parser: parser, Sender: newSender(*fakeAliens, *alienURL, *apiKey),
+		Config: eval.ReducerConfig{MaxStepCount: *maxSteps}}
+	session := &interact.Session{Protocol: *protocolId}
+	if len(*sessionFile) > 0 {
+		session = readSession(*sessionFile, *protocolId)
+	}
+	server, err := viewer.NewServer(context.Background(), driver, eval.NewRef(*protocolId), session)
+	if err != nil {
+		log.Fatalf("Failed to interact with '%v'. Error: %v", *protocolId, err)
+	}
+	log.Printf("Serving viewer of '%v' on http://%v/", *protocolId, *addr)
+	log.Fatalln(http.ListenAndServe(*addr, server))
+}
+
+// runExplore searches the screens of a protocol, e.g. 'app explore -max_depth 3'.
+func runExplore(args []string) {
+	flags := flag.NewFlagSet("explore", flag.ExitOnError)
+	inputFile := flags.String("input_file", "galaxy.txt",
+		"Filename to parse expressions from.")
+	protocolId := flags.String("protocol", "galaxy",
+		"Name of the protocol to explore.")
+	sessionFile := flags.String("session", "",
+		"Filename of a session to start exploring from the end of.")
+	grid := flags.String("grid", "",
+		"Corners of the grid of points to click, e.g. '-8,-8;8,8'. Clicks drawn points by default.")
+	gridStep := flags.Int64("grid_step", 1,
+		"Distance between the points of -grid.")
+	workers := flags.Int("workers", runtime.NumCPU(),
+		"Number of interactions to evaluate in parallel.")
+	maxDepth := flags.Int("max_depth", 2,
+		"Number of clicks to go from the start. Zero means no limit.")
+	maxScreens := flags.Int("max_screens", 100,
+		"Stop exploring after finding this many screens. Zero means no limit.")
+	maxSteps := flags.Int("max_steps", 0,
+		"Stop evaluating an interaction after this many steps. Zero means no limit.")
+	timeout := flags.Duration("timeout", 0,
+		"Stop exploring after this long. Zero means no limit.")
+	fakeAliens := flags.Bool("fake_aliens", false,
+		"Send data to a local stand-in for the aliens' server.")
+	jsonFile := flags.String("json", "",
+		"Filename to write the graph of screens to as JSON.")
+	dotFile := flags.String("dot", "",
+		"Filename to write the graph of screens to in the DOT language.")
+	if err := flags.Parse(args); err != nil {
+		log.Fatalln(err)
+	}
+	parser := parseFile(*inputFile)
+	if _, ok := parser.Vars[*protocolId]; !ok {
+		log.Fatalf("Unknown variable: '%v'\n", *protocolId)
+	}
+	driver := &interact.Driver{Parser: parser, Sender: newSender(*fakeAliens, "", ""),
+		Config: eval.ReducerConfig{MaxStepCount: *maxSteps}}
+	explorer := &explore.Explorer{Driver: driver, Protocol: eval.NewRef(*protocolId), Workers: *workers,
+		MaxDepth: *maxDepth, MaxScreens: *maxScreens}
+	if len(*grid) > 0 {
+		corners, err := interact.ParsePoints(*grid)
+		if err != nil || len(corners) != 2 {
+			log.Fatalf("Expected two corners for -grid: %q %v", *grid, err)
+		}
+		explorer.Candidates = explore.Grid(corners[0], corners[1], *gridStep)
+	}
+	start := eval.NewList()
+	if len(*sessionFile) > 0 {
+		session := readSession(*sessionFile, *protocolId)
+		start, _ = session.State(len(session.Steps))
+	}
+	ctx, cancel := newContext(*timeout)
+	graph, err := explorer.Explore(ctx, start)
+	cancel()
+	if graph == nil {
+		log.Fatalf("Failed to explore: %v", err)
+	}
+	if err != nil {
+		log.Printf("Stopped exploring early: %v", err)
+	}
+	if len(*jsonFile) > 0 {
+		writeFile(*jsonFile, graph.WriteJSON)
+	}
+	if len(*dotFile) > 0 {
+		writeFile(*dotFile, graph.WriteDOT)
+	}
+	fmt.Println(graph)
+}
+
+// runAnalyze reports how definitions refer to each other, e.g.
+// 'app analyze -dot galaxy.dot'.
+func runAnalyze(args []string) {
+	flags := flag.NewFlagSet("analyze", flag.ExitOnError)
+	inputFile := flags.String("input_file", "galaxy.txt",
+		"Filename to parse expressions from.")
+	root := flags.String("root", "galaxy",
+		"Name of the definition to look for unreachable definitions from.")
+	jsonFile := flags.String("json", "",
+		"Filename to write the summary and the definitions to as JSON.")
+	dotFile := flags.String("dot", "",
+		"Filename to write the graph of definitions to in the DOT language.")
+	if err := flags.Parse(args); err != nil {
+		log.Fatalln(err)
+	}
+	parser := parseFile(*inputFile)
+	if _, ok := parser.Vars[*root]; !ok {
+		log.Fatalf("Unknown variable: '%v'\n", *root)
+	}
+	graph := analysis.Analyze(parser.Vars, *root)
+	if len(*jsonFile) > 0 {
+		writeFile(*jsonFile, graph.WriteJSON)
+	}
+	if len(*dotFile) > 0 {
+		writeFile(*dotFile, graph.WriteDOT)
+	}
+	fmt.Println(graph)
+}
+
+// runCompile translates a program into definitions of combinators, e.g.
+// 'app compile -input_file program.txt -output_file compiled.txt'.
+func runCompile(args []string) {
+	flags := flag.NewFlagSet("compile", flag.ExitOnError)
+	inputFile := flags.String("input_file", "",
+		"Filename of the program to compile.")
+	outputFile := flags.String("output_file", "",
+		"Filename to write the definitions to. Standard output if empty.")
+	first := flags.Int64("first", 1,
+		"Number of the first definition.")
+	if err := flags.Parse(args); err != nil {
+		log.Fatalln(err)
+	}
+	if len(*inputFile) == 0 {
+		log.Fatalln("Missing -input_file")
+	}
+	file, err := os.Open(*inputFile)
+	if err != nil {
+		log.Fatalln("Failed to open file: ", *inputFile, "  error: ", err)
+	}
+	program, err := compile.Compile(file, compile.Config{First: *first})
+	if err != nil {
+		log.Fatalln("Failed to compile file: ", *inputFile, "  error: ", err)
+	}
+	if err := file.Close(); err != nil {
+		log.Fatalln("Failed to close file: ", *inputFile, "  error: ", err)
+	}
+	sources := make(map[string]string)
+	for source, name := range program.Names {
+		sources[name] = source
+	}
+	for _, name := range eval.DefinitionNames(program.Vars) {
+		_, ioErr := fmt.Fprintf(os.Stderr, "%v is %v\n", sources[name], name)
+		if ioErr != nil {
+			// Do nothing.
+		}
+	}
+	if len(*outputFile) > 0 {
+		writeFile(*outputFile, program.Write)
+	} else if err := program.Write(os.Stdout); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// runDecompile prints definitions as pseudo-Haskell, e.g.
+// 'app decompile -definition :1141'.
+func runDecompile(args []string) {
+	flags := flag.NewFlagSet("decompile", flag.ExitOnError)
+	inputFile := flags.String("input_file", "galaxy.txt",
+		"Filename to parse expressions from.")
+	outputFile := flags.String("output_file", "",
+		"Filename to write the pseudo-Haskell to. Standard output if empty.")
+	definition := flags.String("definition", "",
+		"Name of the definition to decompile. All of them if empty.")
+	if err := flags.Parse(args); err != nil {
+		log.Fatalln(err)
+	}
+	parser := parseFile(*inputFile)
+	if len(*definition) > 0 {
+		if _, ok := parser.Vars[*definition]; !ok {
+			log.Fatalf("Unknown variable: '%v'\n", *definition)
+		}
+		fmt.Println(decompile.Definition(parser.Vars, *definition))
+		return
+	}
+	write := func(w io.Writer) error {
+		return decompile.WriteDefinitions(w, parser.Vars)
+	}
+	if len(*outputFile) > 0 {
+		writeFile(*outputFile, write)
+	} else if err := write(os.Stdout); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// runInteract runs a click macro, e.g. 'app interact -script tutorial.txt'.
+func runInteract(args []string) {
+	flags := flag.NewFlagSet("interact", flag.ExitOnError)
+	inputFile := flags.String("input_file", "galaxy.txt",
+		"Filename to parse expressions from.")
+	protocolId := flags.String("protocol", "galaxy",
+		"Name of the protocol to interact with.")
+	scriptFile := flags.String("script", "",
+		"Filename of the click macro to run.")
+	loadSession := flags.String("load_session", "",
+		"Filename of a session to continue.")
+	saveSession := flags.String("save_session", "",
+		"Filename to save the session to after running the script.")
+	maxSteps := flags.Int("max_steps", 0,
+		"Stop evaluating an interaction after this many steps. Zero means no limit.")
+	timeout := flags.Duration("timeout", 0,
+		"Stop running the script after this long. Zero means no limit.")
+	alienURL := flags.String("alien_url", "",
+		"Address of the aliens' server to send data to.")
+	apiKey := flags.String("api_key", "",
+		"API key for the aliens' server.")
+	fakeAliens := flags.Bool("fake_aliens", false,
+		"Send data to a local stand-in for the aliens' server.")
+	pngFile := flags.String("png", "",
+		"Filename to write the final pictures to as PNG.")
+	svgFile := flags.String("svg", "",
+		"Filename to write the final pictures to as SVG.")
+	axes := flags.Bool("axes", false,
+		"Draw axes through the origin when rendering pictures.")
+	glyphs := flags.Bool("glyphs", false,
+		"Decode the numbers and symbols drawn in pictures.")
+	if err := flags.Parse(args); err != nil {
+		log.Fatalln(err)
+	}
+	if len(*scriptFile) == 0 {
+		log.Fatalln("Missing -script")
+	}
+	file, err := os.Open(*scriptFile)
+	if err != nil {
+		log.Fatalln("Failed to open file: ", *scriptFile, "  error: ", err)
+	}
+	script, err := interact.ParseScript(file)
+	if err != nil {
+		log.Fatalln("Failed to parse script: ", *scriptFile, "  error: ", err)
+	}
+	if err := file.Close(); err != nil {
+		log.Fatalln("Failed to close file: ", *scriptFile, "  error: ", err)
+	}
+	parser := parseFile(*inputFile)
+	if _, ok := parser.Vars[*protocolId]; !ok {
+		log.Fatalf("Unknown variable: '%v'\n", *protocolId)
+	}
+	driver := &interact.Driver{Parser: parser, Sender: newSender(*fakeAliens, *alienURL, *apiKey),
+		Config: eval.ReducerConfig{MaxStepCount: *maxSteps}}
+	session := &interact.Session{Protocol: *protocolId}
+	if len(*loadSession) > 0 {
+		session = readSession(*loadSession, *protocolId)
+	}
+	ctx, cancel := newContext(*timeout)
+	screen, err := driver.RunScript(ctx, eval.NewRef(*protocolId), script, session)
+	cancel()
+	if len(*saveSession) > 0 {
+		writeFile(*saveSession, session.Save)
+	}
+	printScreen(screen.State, screen.Pictures, eval.PrintOptions{},
+		screenOutput{png: *pngFile, svg: *svgFile, terminal: true, axes: *axes, glyphs: *glyphs})
+	if err != nil {
+		log.Fatalf("Script failed after %v clicks. Error: %v", len(session.Steps), err)
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "interact":
+			runInteract(os.Args[2:])
+			return
+		case "serve":
+			runServe(os.Args[2:])
+			return
+		case "explore":
+			runExplore(os.Args[2:])
+			return
+		case "analyze":
+			runAnalyze(os.Args[2:])
+			return
+		case "compile":
+			runCompile(os.Args[2:])
+			return
+		case "decompile":
+			runDecompile(os.Args[2:])
+			return
+		}
+	}
+
 	inputFile := flag.String("input_file", "",
 		"Filename to parse expressions from.")
 	evaluateId := flag.String("evaluate", "",
 		"Name of the expression to evaluate.")
+	printAddr := flag.Bool("print_expr_addr", false,
+		"Print address of expression Nodes.")
+	showSharing := flag.Bool("show_expr_sharing", false,
+		"Print shared expression Nodes only once.")
+	timeout := flag.Duration("timeout", 0,
+		"Stop evaluating after this long. Zero means no limit.")
+	maxSteps := flag.Int("max_steps", 0,
+		"Stop evaluating after this many steps. Zero means no limit.")
+	protocolId := flag.String("protocol", "",
+		"Name of the protocol to interact with, e.g. 'galaxy'.")
+	clicks := flag.String("clicks", "0,0",
+		"Points to click in order when interacting with -protocol, e.g. '0,0;8,4'.")
+	alienURL := flag.String("alien_url", "",
+		"Address of the aliens' server to send data to when interacting.")
+	apiKey := flag.String("api_key", "",
+		"API key for the aliens' server.")
+	fakeAliens := flag.Bool("fake_aliens", false,
+		"Send data to a local stand-in for the aliens' server when interacting.")
+	pngFile := flag.String("png", "",
+		"Filename to write the pictures of the last interaction to as PNG.")
+	svgFile := flag.String("svg", "",
+		"Filename to write the pictures of the last interaction to as SVG.")
+	terminal := flag.Bool("terminal", false,
+		"Draw the pictures of the last interaction on the terminal.")
+	axes := flag.Bool("axes", false,
+		"Draw axes through the origin when rendering pictures.")
+	glyphs := flag.Bool("glyphs", false,
+		"Decode the numbers and symbols drawn in pictures.")
+	loadSession := flag.String("load_session", "",
+		"Filename of a session to resume from before clicking.")
+	resumeStep := flag.Int("resume_step", -1,
+		"Number of steps of -load_session to resume after. Negative means all.")
+	replay := flag.Bool("replay", false,
+		"Replay the steps of -load_session instead of trusting its states.")
+	saveSession := flag.String("save_session", "",
+		"Filename to save the session to after clicking.")
+	optimize := flag.String("optimize", "",
+		"Optimizations to make to the definitions, e.g. 'fold,i,combinators,inline,eta' or 'all'.")
 	flag.Parse()
+	printOptions := eval.PrintOptions{ShowAddr: *printAddr, ShowSharing: *showSharing}
 
 	if len(*inputFile) > 0 {
-		bytes, err := ioutil.ReadFile(*inputFile)
-		if err != nil {
-			log.Fatalln("Failed to read file: ", *inputFile, "  error: ", err)
-		}
-		contents := string(bytes)
-		parser := eval.Parser{}
-		if _, err := parser.Parse(contents); err != nil {
-			log.Fatalln("Failed to parse file: ", *inputFile, "  error: ", err)
-		}
-		_, ioErr := fmt.Fprintf(os.Stderr, "Parse finished. Variables: %v  Nodes: %v  Recursive Definitions: %v\n",
-			len(parser.Vars), parser.NodeCount, parser.RecursiveCount)
-		if ioErr != nil {
-			// Do nothing.
+		parser := parseFile(*inputFile)
+		if len(*optimize) > 0 {
+			config, err := eval.ParseOptimizeConfig(*optimize)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			stats := parser.Optimize(config)
+			_, ioErr := fmt.Fprintf(os.Stderr, "Optimization finished. Nodes before: %v  after: %v  Rewrites: %v\n",
+				stats.NodesBefore, stats.NodesAfter, stats.Rewrites)
+			if ioErr != nil {
+				// Do nothing.
+			}
 		}
 		if len(*evaluateId) > 0 {
 			node, ok := parser.Vars[*evaluateId]
 			if !ok {
 				log.Fatalf("Unknown variable: '%v'\n", *evaluateId)
 			}
-			reducer := parser.NewReducer(node, false)
-			//reducer.PrintSteps = true
-			result, err := reducer.ReduceRoot()
+			reducer := parser.NewReducerWithConfig(node, eval.ReducerConfig{MaxStepCount: *maxSteps, Print: printOptions})
+			ctx, cancel := newContext(*timeout)
+			result, err := reducer.ReduceRootContext(ctx)
+			cancel()
 			if err != nil {
 				log.Fatalf("Failed to reduce expression '%v'. Error: %v", *evaluateId, err)
 			}
-			fmt.Printf("result: %v\n", result)
-			fmt.Printf("newstate: %v\n", result.Nodes[1].Nodes[0])
-			fmt.Printf("data: %v\n", result.Nodes[1].Nodes[1].Nodes[0])
-			bytes, err := eval.ModulateList(result.Nodes[1].Nodes[1].Nodes[0], []byte{})
+			_, ioErr := fmt.Fprintf(os.Stderr, "Reduction finished. Steps: %v  CAF hits: %v  CAF misses: %v\n",
+				reducer.StepCount(), reducer.CafHits, reducer.CafMisses)
+			if ioErr != nil {
+				// Do nothing.
+			}
+			fmt.Printf("result: %v\n", printOptions.Sprint(result))
+			if bytes, err := eval.ModulateList(result, []byte{}); err == nil {
+				fmt.Printf("modulated result: %v\n", string(bytes))
+			}
+		}
+		if len(*protocolId) > 0 {
+			points, err := interact.ParsePoints(*clicks)
 			if err != nil {
-				log.Fatalf("Failed to modulate data: %v", err)
+				log.Fatalf("Failed to parse clicks: %v", err)
+			}
+			if _, ok := parser.Vars[*protocolId]; !ok {
+				log.Fatalf("Unknown variable: '%v'\n", *protocolId)
+			}
+			driver := &interact.Driver{Parser: parser,
+				Config: eval.ReducerConfig{MaxStepCount: *maxSteps, Print: printOptions}}
+			driver.Sender = newSender(*fakeAliens, *alienURL, *apiKey)
+			ctx, cancel := newContext(*timeout)
+			protocol := eval.NewRef(*protocolId)
+			session := &interact.Session{Protocol: *protocolId}
+			if len(*loadSession) > 0 {
+				session = readSession(*loadSession, *protocolId)
+				step := *resumeStep
+				if step < 0 {
+					step = len(session.Steps)
+				}
+				if *replay {
+					if session, err = driver.Replay(ctx, protocol, session, step); err != nil {
+						log.Fatalf("Failed to replay session: %v", err)
+					}
+				} else if _, err := session.State(step); err != nil {
+					log.Fatalf("Failed to resume session: %v", err)
+				}
+				session.Truncate(step)
+			}
+			state, _ := session.State(len(session.Steps))
+			for _, point := range points {
+				result, err := driver.Interact(ctx, protocol, state, interact.NewVector(point))
+				if err != nil {
+					log.Fatalf("Failed to interact at %v,%v. Error: %v", point.X, point.Y, err)
+				}
+				session.Record(state, point, result)
+				state = result.State
+			}
+			cancel()
+			if len(*saveSession) > 0 {
+				writeFile(*saveSession, session.Save)
+			}
+			var pictures []*eval.Picture
+			if len(session.Steps) > 0 {
+				pictures = session.Steps[len(session.Steps)-1].Pictures
 			}
-			fmt.Printf("modulated data: %v\n", string(bytes))
+			output := screenOutput{png: *pngFile, svg: *svgFile, terminal: *terminal, axes: *axes, glyphs: *glyphs}
+			printScreen(state, pictures, printOptions, output)
 		}
 		return
 	}
@@ -0,0 +1,294 @@
+// Package render draws the pictures of an interaction as PNG, SVG or as
+// Unicode half blocks on a terminal.
+//
+// All formats share the same canvas: y grows downwards, every picture is a
+// layer with its own color, and points covered by several layers get the
+// average of their colors so overlaps stay visible.
+package render
+
+import (
+	"app/eval"
+	"bufio"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+)
+
+// Annotation labels a box of points, such as a glyph.
+type Annotation struct {
+	Min, Max eval.Point // Inclusive.
+	Text     string
+}
+
+// Options controls how pictures are rendered.
+type Options struct {
+	Scale       int   // Size of a point in PNG and SVG pixels. Defaults to 4.
+	Margin      int64 // Empty points around the pictures.
+	Axes        bool  // Draw the x and y axes through the origin.
+	Color       bool  // Use ANSI colors for terminal output.
+	Annotations []Annotation
+}
+
+// maxCanvasSize limits the width and height of a rendered image in pixels, or
+// in characters on a terminal.
+const maxCanvasSize = 4096
+
+var (
+	Background      = color.RGBA{0, 0, 0, 255}
+	AxisColor       = color.RGBA{80, 80, 80, 255}
+	AnnotationColor = color.RGBA{255, 255, 0, 255}
+)
+
+// palette holds the layer colors, starting with the topmost picture.
+var palette = []color.RGBA{
+	{255, 255, 255, 255},
+	{255, 80, 80, 255},
+	{80, 200, 80, 255},
+	{80, 140, 255, 255},
+	{255, 210, 60, 255},
+	{210, 90, 255, 255},
+	{60, 220, 220, 255},
+	{255, 150, 40, 255},
+}
+
+// LayerColor returns the color of the picture at position layer. Colors
+// repeat after len(palette) layers.
+func LayerColor(layer int) color.RGBA {
+	return palette[layer%len(palette)]
+}
+
+type cell struct {
+	r, g, b uint32
+	layers  uint32
+	axis    bool
+}
+
+// canvas is a grid of cells covering the bounding box of some pictures.
+type canvas struct {
+	min           eval.Point
+	width, height int64
+	cells         []cell
+}
+
+// newCanvas returns the canvas of pictures, which must fit into maxCanvasSize
+// once every point is drawn as pixels by pixels.
+func newCanvas(pictures []*eval.Picture, opts Options, pixels int) (*canvas, error) {
+	s := int64(pixels)
+	var min, max eval.Point
+	empty := true
+	extend := func(p eval.Point) {
+		if empty {
+			min, max, empty = p, p, false
+			return
+		}
+		if p.X < min.X {
+			min.X = p.X
+		}
+		if p.Y < min.Y {
+			min.Y = p.Y
+		}
+		if p.X > max.X {
+			max.X = p.X
+		}
+		if p.Y > max.Y {
+			max.Y = p.Y
+		}
+	}
+	for _, picture := range pictures {
+		if len(picture.Points) > 0 {
+			extend(picture.Min)
+			extend(picture.Max)
+		}
+	}
+	if opts.Axes || empty {
+		extend(eval.Point{})
+	}
+	if opts.Margin < 0 || opts.Margin > maxCanvasSize {
+		return nil, errors.New(fmt.Sprintf("margin out of range: %v", opts.Margin))
+	}
+	// The difference of points far apart wraps around to a negative number.
+	if max.X-min.X < 0 || max.X-min.X >= maxCanvasSize || max.Y-min.Y < 0 || max.Y-min.Y >= maxCanvasSize {
+		return nil, errors.New(fmt.Sprintf("pictures too large to render: (%v,%v)-(%v,%v)",
+			min.X, min.Y, max.X, max.Y))
+	}
+	// Scaled coordinates end up in SVG, so they have to stay within int64.
+	if min.X < math.MinInt64/s+opts.Margin || min.Y < math.MinInt64/s+opts.Margin ||
+		max.X > math.MaxInt64/s-opts.Margin || max.Y > math.MaxInt64/s-opts.Margin {
+		return nil, errors.New(fmt.Sprintf("pictures too far from the origin to render: (%v,%v)-(%v,%v)",
+			min.X, min.Y, max.X, max.Y))
+	}
+	min.X -= opts.Margin
+	min.Y -= opts.Margin
+	max.X += opts.Margin
+	max.Y += opts.Margin
+	c := &canvas{min: min, width: max.X - min.X + 1, height: max.Y - min.Y + 1}
+	if c.width > maxCanvasSize/s || c.height > maxCanvasSize/s {
+		return nil, errors.New(fmt.Sprintf("pictures too large to render: %vx%v at scale %v",
+			c.width, c.height, s))
+	}
+	c.cells = make([]cell, c.width*c.height)
+	if opts.Axes {
+		for x := int64(0); x < c.width; x += 1 {
+			c.cell(x, -min.Y).axis = true
+		}
+		for y := int64(0); y < c.height; y += 1 {
+			c.cell(-min.X, y).axis = true
+		}
+	}
+	for layer, picture := range pictures {
+		color := LayerColor(layer)
+		for _, point := range picture.Points {
+			cell := c.cell(point.X-min.X, point.Y-min.Y)
+			cell.r += uint32(color.R)
+			cell.g += uint32(color.G)
+			cell.b += uint32(color.B)
+			cell.layers += 1
+		}
+	}
+	return c, nil
+}
+
+// cell returns the cell at x, y relative to the canvas' top left corner.
+func (c *canvas) cell(x, y int64) *cell {
+	return &c.cells[y*c.width+x]
+}
+
+// color returns the color of the cell at x, y and whether anything is drawn
+// there.
+func (c *canvas) color(x, y int64) (color.RGBA, bool) {
+	cell := c.cell(x, y)
+	switch {
+	case cell.layers > 0:
+		return color.RGBA{uint8(cell.r / cell.layers), uint8(cell.g / cell.layers),
+			uint8(cell.b / cell.layers), 255}, true
+	case cell.axis:
+		return AxisColor, true
+	default:
+		return Background, false
+	}
+}
+
+func scale(opts Options) int {
+	if opts.Scale <= 0 {
+		return 4
+	}
+	return opts.Scale
+}
+
+// PNG writes the pictures to w as a PNG image.
+func PNG(w io.Writer, pictures []*eval.Picture, opts Options) error {
+	s := scale(opts)
+	c, err := newCanvas(pictures, opts, s)
+	if err != nil {
+		return err
+	}
+	img := image.NewRGBA(image.Rect(0, 0, int(c.width)*s, int(c.height)*s))
+	for y := int64(0); y < c.height; y += 1 {
+		for x := int64(0); x < c.width; x += 1 {
+			color, _ := c.color(x, y)
+			for dy := 0; dy < s; dy += 1 {
+				for dx := 0; dx < s; dx += 1 {
+					img.SetRGBA(int(x)*s+dx, int(y)*s+dy, color)
+				}
+			}
+		}
+	}
+	// Outlines go around the points of annotations, as there are no fonts.
+	for _, annotation := range opts.Annotations {
+		x0, y0 := int(annotation.Min.X-c.min.X)*s-1, int(annotation.Min.Y-c.min.Y)*s-1
+		x1, y1 := int(annotation.Max.X-c.min.X+1)*s, int(annotation.Max.Y-c.min.Y+1)*s
+		for x := x0; x <= x1; x += 1 {
+			img.SetRGBA(x, y0, AnnotationColor)
+			img.SetRGBA(x, y1, AnnotationColor)
+		}
+		for y := y0; y <= y1; y += 1 {
+			img.SetRGBA(x0, y, AnnotationColor)
+			img.SetRGBA(x1, y, AnnotationColor)
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// SVG writes the pictures to w as an SVG image with one square per point.
+func SVG(w io.Writer, pictures []*eval.Picture, opts Options) error {
+	c, err := newCanvas(pictures, opts, scale(opts))
+	if err != nil {
+		return err
+	}
+	s := int64(scale(opts))
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%v\" height=\"%v\" viewBox=\"%v %v %v %v\">\n",
+		c.width*s, c.height*s, c.min.X*s, c.min.Y*s, c.width*s, c.height*s)
+	fmt.Fprintf(out, "<rect x=\"%v\" y=\"%v\" width=\"%v\" height=\"%v\" fill=\"%v\"/>\n",
+		c.min.X*s, c.min.Y*s, c.width*s, c.height*s, hex(Background))
+	for y := int64(0); y < c.height; y += 1 {
+		for x := int64(0); x < c.width; x += 1 {
+			if color, ok := c.color(x, y); ok {
+				fmt.Fprintf(out, "<rect x=\"%v\" y=\"%v\" width=\"%v\" height=\"%v\" fill=\"%v\"/>\n",
+					(c.min.X+x)*s, (c.min.Y+y)*s, s, s, hex(color))
+			}
+		}
+	}
+	for _, annotation := range opts.Annotations {
+		fmt.Fprintf(out, "<rect x=\"%v\" y=\"%v\" width=\"%v\" height=\"%v\" fill=\"none\" stroke=\"%v\"/>\n",
+			annotation.Min.X*s, annotation.Min.Y*s, (annotation.Max.X-annotation.Min.X+1)*s,
+			(annotation.Max.Y-annotation.Min.Y+1)*s, hex(AnnotationColor))
+		fmt.Fprintf(out, "<text x=\"%v\" y=\"%v\" font-size=\"%v\" fill=\"%v\">%v</text>\n",
+			annotation.Min.X*s, annotation.Min.Y*s-1, 2*s, hex(AnnotationColor), html.EscapeString(annotation.Text))
+	}
+	fmt.Fprintln(out, "</svg>")
+	return out.Flush()
+}
+
+func hex(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// Terminal writes the pictures to w using Unicode half blocks, so every line
+// of text holds two rows of points.
+func Terminal(w io.Writer, pictures []*eval.Picture, opts Options) error {
+	c, err := newCanvas(pictures, opts, 1)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(w)
+	for y := int64(0); y < c.height; y += 2 {
+		for x := int64(0); x < c.width; x += 1 {
+			top, topSet := c.color(x, y)
+			bottom, bottomSet := Background, false
+			if y+1 < c.height {
+				bottom, bottomSet = c.color(x, y+1)
+			}
+			if opts.Color {
+				fmt.Fprintf(out, "\x1b[38;2;%v;%v;%vm\x1b[48;2;%v;%v;%vm▀",
+					top.R, top.G, top.B, bottom.R, bottom.G, bottom.B)
+				continue
+			}
+			switch {
+			case topSet && bottomSet:
+				out.WriteString("█")
+			case topSet:
+				out.WriteString("▀")
+			case bottomSet:
+				out.WriteString("▄")
+			default:
+				out.WriteString(" ")
+			}
+		}
+		if opts.Color {
+			out.WriteString("\x1b[0m")
+		}
+		out.WriteString("\n")
+	}
+	// Text doesn't fit between the points, so annotations follow as a legend.
+	for _, annotation := range opts.Annotations {
+		fmt.Fprintf(out, "(%v,%v)-(%v,%v): %v\n", annotation.Min.X, annotation.Min.Y,
+			annotation.Max.X, annotation.Max.Y, annotation.Text)
+	}
+	return out.Flush()
+}
@@ -0,0 +1,135 @@
+package render
+
+import (
+	"app/eval"
+	"bytes"
+	"image/png"
+	"math"
+	"strings"
+	"testing"
+)
+
+func picture(points ...eval.Point) *eval.Picture {
+	return eval.NewPicture(points)
+}
+
+func TestTerminal(t *testing.T) {
+	tests := []struct {
+		pictures []*eval.Picture
+		opts     Options
+		expected string
+	}{
+		// Test 0
+		{nil, Options{}, " \n"},
+		// Test 1
+		{[]*eval.Picture{picture(eval.Point{X: 1, Y: 1}, eval.Point{X: 2, Y: 2}, eval.Point{X: 1, Y: 2})},
+			Options{}, "█▄\n"},
+		// Test 2
+		{[]*eval.Picture{picture(eval.Point{X: 1, Y: 1})}, Options{Axes: true}, "██\n"},
+		// Test 3
+		{[]*eval.Picture{picture(eval.Point{X: 0, Y: 0})}, Options{Margin: 1}, " ▄ \n   \n"},
+		// Test 4
+		{[]*eval.Picture{picture(eval.Point{X: -1, Y: 0}), picture(eval.Point{X: -1, Y: 1})},
+			Options{}, "█\n"},
+		// Test 5
+		{[]*eval.Picture{picture(eval.Point{X: 1, Y: 0}, eval.Point{X: 0, Y: 1})},
+			Options{Annotations: []Annotation{{eval.Point{X: 0, Y: 0}, eval.Point{X: 1, Y: 1}, "0"}}},
+			"▄▀\n(0,0)-(1,1): 0\n"},
+	}
+	for testId, test := range tests {
+		var out strings.Builder
+		if err := Terminal(&out, test.pictures, test.opts); err != nil {
+			t.Errorf("Test %v: Failed to render: %v", testId, err)
+		} else if got := out.String(); got != test.expected {
+			t.Errorf("Test %v: Expected:\n%q\ngot:\n%q", testId, test.expected, got)
+		}
+	}
+}
+
+func TestTerminalColor(t *testing.T) {
+	var out strings.Builder
+	pictures := []*eval.Picture{picture(eval.Point{X: 0, Y: 0}), picture(eval.Point{X: 0, Y: 1})}
+	if err := Terminal(&out, pictures, Options{Color: true}); err != nil {
+		t.Fatalf("Failed to render: %v", err)
+	}
+	expected := "\x1b[38;2;255;255;255m\x1b[48;2;255;80;80m▀\x1b[0m\n"
+	if got := out.String(); got != expected {
+		t.Errorf("Expected: %q, got: %q", expected, got)
+	}
+}
+
+func TestPNG(t *testing.T) {
+	pictures := []*eval.Picture{
+		picture(eval.Point{X: 0, Y: 0}, eval.Point{X: 1, Y: 0}),
+		picture(eval.Point{X: 1, Y: 0}, eval.Point{X: 1, Y: 1}),
+	}
+	var out bytes.Buffer
+	if err := PNG(&out, pictures, Options{Scale: 2}); err != nil {
+		t.Fatalf("Failed to render: %v", err)
+	}
+	img, err := png.Decode(&out)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if size := img.Bounds().Size(); size.X != 4 || size.Y != 4 {
+		t.Errorf("Expected 4x4 image, got: %v", size)
+	}
+	tests := []struct {
+		x, y    int
+		r, g, b uint32
+	}{
+		// Test 0
+		{0, 0, 255, 255, 255},
+		// Test 1
+		{3, 1, 255, 167, 167},
+		// Test 2
+		{2, 3, 255, 80, 80},
+		// Test 3
+		{1, 2, 0, 0, 0},
+	}
+	for testId, test := range tests {
+		r, g, b, _ := img.At(test.x, test.y).RGBA()
+		if r>>8 != test.r || g>>8 != test.g || b>>8 != test.b {
+			t.Errorf("Test %v: Expected color %v,%v,%v at %v,%v, got: %v,%v,%v", testId,
+				test.r, test.g, test.b, test.x, test.y, r>>8, g>>8, b>>8)
+		}
+	}
+}
+
+func TestSVG(t *testing.T) {
+	var out strings.Builder
+	pictures := []*eval.Picture{picture(eval.Point{X: -1, Y: 2})}
+	if err := SVG(&out, pictures, Options{Scale: 3}); err != nil {
+		t.Fatalf("Failed to render: %v", err)
+	}
+	expected := "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"3\" height=\"3\" viewBox=\"-3 6 3 3\">\n" +
+		"<rect x=\"-3\" y=\"6\" width=\"3\" height=\"3\" fill=\"#000000\"/>\n" +
+		"<rect x=\"-3\" y=\"6\" width=\"3\" height=\"3\" fill=\"#ffffff\"/>\n" +
+		"</svg>\n"
+	if got := out.String(); got != expected {
+		t.Errorf("Expected:\n%v\ngot:\n%v", expected, got)
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	var out strings.Builder
+	pictures := []*eval.Picture{picture(eval.Point{X: 0, Y: 0}, eval.Point{X: maxCanvasSize, Y: 0})}
+	if err := Terminal(&out, pictures, Options{}); err == nil {
+		t.Errorf("Expected error for picture of width %v", maxCanvasSize+1)
+	}
+	far := []*eval.Picture{picture(eval.Point{X: math.MinInt64, Y: 0}, eval.Point{X: math.MaxInt64, Y: 0})}
+	if err := Terminal(&out, far, Options{}); err == nil {
+		t.Errorf("Expected error for points at both ends of int64")
+	}
+	edge := []*eval.Picture{picture(eval.Point{X: math.MaxInt64, Y: 0})}
+	if err := SVG(&out, edge, Options{Margin: 1}); err == nil {
+		t.Errorf("Expected error for a point that overflows once scaled")
+	}
+	wide := []*eval.Picture{picture(eval.Point{X: 0, Y: 0}, eval.Point{X: maxCanvasSize / 2, Y: 0})}
+	if err := Terminal(&out, wide, Options{}); err != nil {
+		t.Errorf("Failed to render picture of width %v on a terminal: %v", maxCanvasSize/2+1, err)
+	}
+	if err := PNG(&out, wide, Options{}); err == nil {
+		t.Errorf("Expected error for picture of width %v at the default scale", maxCanvasSize/2+1)
+	}
+}
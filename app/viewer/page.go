@@ -0,0 +1,155 @@
+package viewer
+
+// page is the viewer's user interface. Dragging pans, the mouse wheel zooms
+// around the pointer and a click without dragging is sent to the protocol.
+const page = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Galaxy viewer</title>
+<style>
+  body { margin: 0; display: flex; height: 100vh; font: 13px monospace; background: #111; color: #ddd; }
+  #main { flex: 1; display: flex; flex-direction: column; }
+  #toolbar { padding: 4px; border-bottom: 1px solid #333; }
+  #toolbar button { font: inherit; }
+  #toolbar a { color: #8af; }
+  #canvas { flex: 1; width: 100%; cursor: crosshair; }
+  #sidebar { width: 320px; overflow-y: auto; border-left: 1px solid #333; }
+  #sidebar div { padding: 4px; border-bottom: 1px solid #222; cursor: pointer; word-break: break-all; }
+  #sidebar div.current { background: #234; }
+  #error { color: #f66; }
+</style>
+</head>
+<body>
+<div id="main">
+  <div id="toolbar">
+    <button id="back">&larr; back</button>
+    <button id="forward">forward &rarr;</button>
+    <button id="zoomin">+</button>
+    <button id="zoomout">&minus;</button>
+    <button id="fit">fit</button>
+    <a href="/api/session" download="session.json">save session</a>
+    <span id="pointer"></span>
+    <span id="error"></span>
+  </div>
+  <canvas id="canvas"></canvas>
+</div>
+<div id="sidebar"></div>
+<script>
+"use strict";
+const canvas = document.getElementById("canvas");
+const ctx = canvas.getContext("2d");
+let view = {current: 0, history: [], layers: []};
+let scale = 8, offsetX = 0, offsetY = 0;
+let drag = null;
+
+function toGalaxy(event) {
+  const rect = canvas.getBoundingClientRect();
+  return [Math.floor((event.clientX - rect.left - offsetX) / scale),
+          Math.floor((event.clientY - rect.top - offsetY) / scale)];
+}
+
+function draw() {
+  canvas.width = canvas.clientWidth;
+  canvas.height = canvas.clientHeight;
+  ctx.fillStyle = "#000";
+  ctx.fillRect(0, 0, canvas.width, canvas.height);
+  ctx.globalAlpha = 0.6;
+  for (let i = view.layers.length - 1; i >= 0; i--) {
+    ctx.fillStyle = view.layers[i].color;
+    for (const [x, y] of view.layers[i].points) {
+      ctx.fillRect(offsetX + x * scale, offsetY + y * scale, scale, scale);
+    }
+  }
+  ctx.globalAlpha = 1;
+}
+
+function fit() {
+  let minX = 0, minY = 0, maxX = 0, maxY = 0;
+  for (const layer of view.layers) {
+    for (const [x, y] of layer.points) {
+      minX = Math.min(minX, x); minY = Math.min(minY, y);
+      maxX = Math.max(maxX, x); maxY = Math.max(maxY, y);
+    }
+  }
+  const w = canvas.clientWidth, h = canvas.clientHeight;
+  scale = Math.max(1, Math.floor(Math.min(w / (maxX - minX + 3), h / (maxY - minY + 3))));
+  offsetX = Math.floor((w - (maxX + minX + 1) * scale) / 2);
+  offsetY = Math.floor((h - (maxY + minY + 1) * scale) / 2);
+  draw();
+}
+
+function zoom(factor, px, py) {
+  const scaled = factor > 1 ? Math.ceil(scale * factor) : Math.floor(scale * factor);
+  const next = Math.max(1, Math.min(64, scaled));
+  offsetX = px - (px - offsetX) * next / scale;
+  offsetY = py - (py - offsetY) * next / scale;
+  scale = next;
+  draw();
+}
+
+function show(next) {
+  view = next;
+  const sidebar = document.getElementById("sidebar");
+  sidebar.innerHTML = "";
+  view.history.forEach((step, index) => {
+    const div = document.createElement("div");
+    div.textContent = index + ": " + step.click + " → " + step.state;
+    if (index === view.current) div.className = "current";
+    div.onclick = () => call("/api/goto", {index: index});
+    sidebar.appendChild(div);
+  });
+  draw();
+}
+
+async function call(path, params) {
+  document.getElementById("error").textContent = "";
+  const body = new URLSearchParams(params);
+  const response = await fetch(path, params ? {method: "POST", body: body} : {});
+  if (!response.ok) {
+    document.getElementById("error").textContent = await response.text();
+    return;
+  }
+  show(await response.json());
+}
+
+canvas.addEventListener("mousedown", e => { drag = {x: e.clientX, y: e.clientY, moved: false}; });
+canvas.addEventListener("mousemove", e => {
+  const [x, y] = toGalaxy(e);
+  document.getElementById("pointer").textContent = x + "," + y;
+  if (!drag) return;
+  const dx = e.clientX - drag.x, dy = e.clientY - drag.y;
+  if (drag.moved || Math.abs(dx) + Math.abs(dy) > 3) {
+    drag.moved = true;
+    offsetX += dx; offsetY += dy;
+    drag.x = e.clientX; drag.y = e.clientY;
+    draw();
+  }
+});
+canvas.addEventListener("mouseup", e => {
+  if (drag && !drag.moved) {
+    const [x, y] = toGalaxy(e);
+    call("/api/click", {x: x, y: y});
+  }
+  drag = null;
+});
+canvas.addEventListener("wheel", e => {
+  e.preventDefault();
+  const rect = canvas.getBoundingClientRect();
+  zoom(e.deltaY < 0 ? 1.25 : 0.8, e.clientX - rect.left, e.clientY - rect.top);
+});
+document.getElementById("back").onclick = () => {
+  if (view.current > 0) call("/api/goto", {index: view.current - 1});
+};
+document.getElementById("forward").onclick = () => {
+  if (view.current + 1 < view.history.length) call("/api/goto", {index: view.current + 1});
+};
+document.getElementById("zoomin").onclick = () => zoom(2, canvas.width / 2, canvas.height / 2);
+document.getElementById("zoomout").onclick = () => zoom(0.5, canvas.width / 2, canvas.height / 2);
+document.getElementById("fit").onclick = fit;
+window.addEventListener("resize", draw);
+call("/api/view").then(fit);
+</script>
+</body>
+</html>
+`
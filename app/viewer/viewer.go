@@ -0,0 +1,189 @@
+// Package viewer serves a web page for clicking through an interaction
+// protocol such as the galaxy pad.
+//
+// The page draws the pictures of the current step on a canvas and posts
+// clicks back in protocol coordinates. Every click becomes a new step in the
+// history, which can be walked back and forth and saved as a session.
+package viewer
+
+import (
+	"app/eval"
+	"app/interact"
+	"app/render"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// Server is an http.Handler for the viewer page and its API.
+type Server struct {
+	driver   *interact.Driver
+	protocol *eval.Node
+
+	mu      sync.Mutex
+	session *interact.Session // History of clicks.
+	current int               // Index into session.Steps of the step on display.
+}
+
+// NewServer returns a viewer of protocol that continues session, displaying
+// its last step. An empty session is started with a click at the origin.
+func NewServer(ctx context.Context, driver *interact.Driver, protocol *eval.Node,
+	session *interact.Session) (*Server, error) {
+	s := &Server{driver: driver, protocol: protocol, session: session}
+	if len(session.Steps) == 0 {
+		s.current = -1
+		if err := s.Click(ctx, eval.Point{}); err != nil {
+			return nil, err
+		}
+	}
+	s.current = len(session.Steps) - 1
+	return s, nil
+}
+
+// Click interacts with the current step at point. Steps after the current one
+// are dropped from the history. The protocol is evaluated without holding the
+// lock, so the click fails if the step on display changes in the meantime.
+func (s *Server) Click(ctx context.Context, point eval.Point) error {
+	s.mu.Lock()
+	current := s.current
+	var step *interact.Step
+	if current >= 0 {
+		step = s.session.Steps[current]
+	}
+	state, err := s.session.State(current + 1)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	result, err := s.driver.Interact(ctx, s.protocol, state, interact.NewVector(point))
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current != current || current >= 0 && s.session.Steps[current] != step {
+		return errors.New(fmt.Sprintf("step %v changed while clicking at %v,%v", current, point.X, point.Y))
+	}
+	s.session.Truncate(current + 1)
+	s.session.Record(state, point, result)
+	s.current = current + 1
+	return nil
+}
+
+// Goto displays the step at index in the history.
+func (s *Server) Goto(index int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if index < 0 || index >= len(s.session.Steps) {
+		return errors.New(fmt.Sprintf("no step %v in history of %v", index, len(s.session.Steps)))
+	}
+	s.current = index
+	return nil
+}
+
+// Save writes the whole history to w, see interact.Session.Save.
+func (s *Server) Save(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.session.Save(w)
+}
+
+type historyJSON struct {
+	Click string `json:"click"`
+	State string `json:"state"`
+}
+
+type layerJSON struct {
+	Color  string     `json:"color"`
+	Points [][2]int64 `json:"points"`
+}
+
+type viewJSON struct {
+	Current int           `json:"current"`
+	History []historyJSON `json:"history"`
+	Layers  []layerJSON   `json:"layers"`
+}
+
+func (s *Server) view() *viewJSON {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	view := &viewJSON{Current: s.current, History: []historyJSON{}, Layers: []layerJSON{}}
+	for _, step := range s.session.Steps {
+		click := fmt.Sprintf("%v,%v", step.Click.X, step.Click.Y)
+		view.History = append(view.History, historyJSON{click, fmt.Sprint(step.After)})
+	}
+	for pos, picture := range s.session.Steps[s.current].Pictures {
+		c := render.LayerColor(pos)
+		layer := layerJSON{Color: fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B), Points: [][2]int64{}}
+		for _, point := range picture.Points {
+			layer.Points = append(layer.Points, [2]int64{point.X, point.Y})
+		}
+		view.Layers = append(view.Layers, layer)
+	}
+	return view
+}
+
+// ServeHTTP serves the page at / and its API:
+//
+//	GET  /api/view             The history and the pictures on display.
+//	POST /api/click?x=..&y=..  Click at x, y.
+//	POST /api/goto?index=..    Display an earlier or later step.
+//	GET  /api/session          The history as a session file.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if _, err := w.Write([]byte(page)); err != nil {
+			// Do nothing.
+		}
+		return
+	case "/api/session":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", "attachment; filename=session.json")
+		if err := s.Save(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	case "/api/view":
+	case "/api/click":
+		if r.Method != http.MethodPost {
+			http.Error(w, "expected POST", http.StatusMethodNotAllowed)
+			return
+		}
+		point, err := interact.ParsePoint(r.FormValue("x") + "," + r.FormValue("y"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.Click(r.Context(), point); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	case "/api/goto":
+		if r.Method != http.MethodPost {
+			http.Error(w, "expected POST", http.StatusMethodNotAllowed)
+			return
+		}
+		index, err := strconv.Atoi(r.FormValue("index"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.Goto(index); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.view()); err != nil {
+		// Do nothing.
+	}
+}
@@ -0,0 +1,164 @@
+package viewer
+
+import (
+	"app/eval"
+	"app/interact"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	var parser eval.Parser
+	driver := &interact.Driver{Parser: &parser, Config: eval.ReducerConfig{MaxStepCount: 1000}}
+	session := &interact.Session{Protocol: "statelessdraw"}
+	server, err := NewServer(context.Background(), driver, eval.NewFun("statelessdraw"), session)
+	if err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	tests := []struct {
+		method   string
+		path     string
+		params   url.Values
+		status   int
+		current  int
+		history  string
+		points   string
+		contains string
+	}{
+		// Test 0
+		{"GET", "/api/view", nil, http.StatusOK, 0, "[0,0]", "[[[0 0]]]", ""},
+		// Test 1
+		{"POST", "/api/click", url.Values{"x": {"3"}, "y": {"-2"}}, http.StatusOK, 1, "[0,0 3,-2]", "[[[3 -2]]]", ""},
+		// Test 2
+		{"POST", "/api/click", url.Values{"x": {"1"}, "y": {"1"}}, http.StatusOK, 2, "[0,0 3,-2 1,1]", "[[[1 1]]]", ""},
+		// Test 3
+		{"POST", "/api/goto", url.Values{"index": {"1"}}, http.StatusOK, 1, "[0,0 3,-2 1,1]", "[[[3 -2]]]", ""},
+		// Test 4
+		{"POST", "/api/click", url.Values{"x": {"5"}, "y": {"5"}}, http.StatusOK, 2, "[0,0 3,-2 5,5]", "[[[5 5]]]", ""},
+		// Test 5
+		{"POST", "/api/goto", url.Values{"index": {"3"}}, http.StatusBadRequest, 0, "", "", "no step 3"},
+		// Test 6
+		{"POST", "/api/click", url.Values{"x": {"a"}, "y": {"1"}}, http.StatusBadRequest, 0, "", "", "invalid x"},
+		// Test 7
+		{"GET", "/api/click", nil, http.StatusMethodNotAllowed, 0, "", "", "expected POST"},
+		// Test 8
+		{"GET", "/", nil, http.StatusOK, 0, "", "", "<canvas"},
+		// Test 9
+		{"GET", "/missing", nil, http.StatusNotFound, 0, "", "", ""},
+		// Test 10
+		{"GET", "/api/session", nil, http.StatusOK, 0, "", "", `{"click":[5,5],"before":"00","after":"00","pictures":[[[5,5]]]}`},
+	}
+	for testId, test := range tests {
+		var body *strings.Reader
+		if test.params != nil {
+			body = strings.NewReader(test.params.Encode())
+		} else {
+			body = strings.NewReader("")
+		}
+		request := httptest.NewRequest(test.method, test.path, body)
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, request)
+		if recorder.Code != test.status {
+			t.Errorf("Test %v: Expected status %v, got: %v %v", testId, test.status, recorder.Code, recorder.Body)
+			continue
+		}
+		if !strings.Contains(recorder.Body.String(), test.contains) {
+			t.Errorf("Test %v: Expected %q in: %v", testId, test.contains, recorder.Body)
+		}
+		if test.status != http.StatusOK || test.history == "" {
+			continue
+		}
+		var view viewJSON
+		if err := json.Unmarshal(recorder.Body.Bytes(), &view); err != nil {
+			t.Errorf("Test %v: Failed to decode: %v", testId, err)
+			continue
+		}
+		var clicks []string
+		for _, step := range view.History {
+			clicks = append(clicks, step.Click)
+		}
+		var points [][][2]int64
+		for _, layer := range view.Layers {
+			points = append(points, layer.Points)
+		}
+		if view.Current != test.current || fmt.Sprint(clicks) != test.history || fmt.Sprint(points) != test.points {
+			t.Errorf("Test %v: Expected %v %v %v, got: %v %v %v", testId,
+				test.current, test.history, test.points, view.Current, clicks, points)
+		}
+	}
+	if len(session.Steps) != 3 {
+		t.Errorf("Expected 3 steps in session, got: %v", len(session.Steps))
+	}
+	resumed, err := NewServer(context.Background(), driver, eval.NewFun("statelessdraw"), session)
+	if err != nil {
+		t.Fatalf("Failed to resume: %v", err)
+	}
+	if view := resumed.view(); view.Current != 2 || len(view.History) != 3 {
+		t.Errorf("Expected to resume at the last of 3 steps, got: %v of %v", view.Current, len(view.History))
+	}
+}
+
+// blockingSender waits for release on every send, after signaling started.
+type blockingSender struct {
+	started, release chan bool
+}
+
+func (s *blockingSender) Send(ctx context.Context, data *eval.Node) (*eval.Node, error) {
+	s.started <- true
+	<-s.release
+	return eval.NewCons(eval.NewNum(0), eval.NewNum(0)), nil
+}
+
+func TestClickUnlocked(t *testing.T) {
+	var parser eval.Parser
+	// Draws the clicks, except at 2,0 where it sends first.
+	protocol, err := parser.Parse(":p = \\s -> \\v -> ap ap ap if0 ap ap add -2 ap car v " +
+		"ap ap cons 1 ap ap cons nil ap ap cons nil nil ap ap statelessdraw s v")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	sender := &blockingSender{make(chan bool), make(chan bool)}
+	driver := &interact.Driver{Parser: &parser, Sender: sender}
+	server, err := NewServer(context.Background(), driver, protocol, &interact.Session{})
+	if err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	if err := server.Click(context.Background(), eval.Point{X: 1}); err != nil {
+		t.Fatalf("Failed to click: %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- server.Click(context.Background(), eval.Point{X: 2})
+	}()
+	<-sender.started
+	viewed := make(chan *viewJSON)
+	go func() {
+		viewed <- server.view()
+	}()
+	select {
+	case view := <-viewed:
+		if view.Current != 1 {
+			t.Errorf("Expected step 1 on display while clicking, got: %v", view.Current)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Viewing blocked while clicking")
+	}
+	if err := server.Goto(0); err != nil {
+		t.Fatalf("Failed to go to step 0: %v", err)
+	}
+	sender.release <- true
+	if err := <-done; err == nil || !strings.Contains(err.Error(), "step 1 changed") {
+		t.Errorf("Expected the click to fail after going to another step, got: %v", err)
+	}
+	if view := server.view(); view.Current != 0 || len(view.History) != 2 {
+		t.Errorf("Expected step 0 of 2 on display, got: %v of %v", view.Current, len(view.History))
+	}
+}